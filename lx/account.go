@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/zyxar/image2ascii/ascii"
+	"github.com/zyxar/xunlei/protocol"
+)
+
+// defaultAccount is the account configured in config.json, saved to cookie.json;
+// other accounts are saved to accounts/<name>.json.
+const defaultAccount = "default"
+
+var (
+	manager *protocol.SessionManager
+	sess    protocol.Session // the account commands run against
+	current string
+)
+
+func initAccounts(transport http.RoundTripper) {
+	manager = protocol.NewSessionManager(&protocol.Options{
+		CaptchaSolver: captchaSolver(),
+		Transport:     transport,
+	}, accountStore)
+}
+
+// captchaSolver shows the verification image in the shell and reads the code
+// with term.Ask, as the shell keeps the terminal in raw mode; before the shell
+// starts, the code is read from stdin.
+func captchaSolver() protocol.CaptchaSolver {
+	fallback := protocol.NewTerminalSolver(os.Stdin, os.Stdout)
+	return protocol.CaptchaSolverFunc(func(ctx context.Context, image []byte) (string, error) {
+		if term == nil {
+			return fallback.Solve(ctx, image)
+		}
+		w, err := ascii.Decode(bytes.NewReader(image), ascii.Options{
+			Invert: true,
+			Color:  true,
+		})
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(term, "\n")
+		w.WriteTo(term)
+		return term.Ask("input verify_code: ")
+	})
+}
+
+func accountStore(name string) protocol.SessionStore {
+	path := cookieFile
+	if name != defaultAccount {
+		path = filepath.Join(home, "accounts", name+".json")
+	}
+	if passphrase := os.Getenv("LX_PASSPHRASE"); passphrase != "" {
+		return protocol.NewEncryptedFileStore(path, []byte(passphrase))
+	}
+	return protocol.NewFileStore(path)
+}
+
+// accounts returns the names of all saved accounts.
+func accounts() []string {
+	names := []string{defaultAccount}
+	matches, _ := filepath.Glob(filepath.Join(home, "accounts", "*.json"))
+	for i := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(matches[i]), ".json"))
+	}
+	return names
+}
+
+// openAccount resumes the session of name; the default account logs in with
+// the configured credentials if its session cannot be resumed, the others
+// are renewed with their saved credentials on first use.
+func openAccount(name string) (protocol.Session, error) {
+	s, err := manager.Open(context.Background(), name)
+	if s == nil {
+		return nil, err
+	}
+	if name != defaultAccount {
+		if err == protocol.ErrSessionExpired {
+			err = nil
+		}
+		return s, err
+	}
+	s.SetCredentialsProvider(func(context.Context) (string, string, error) {
+		return conf.Id, conf.Pass, nil
+	})
+	if err != nil {
+		log.Warn(err.Error())
+		if _, err = manager.Login(context.Background(), name, conf.Id, conf.Pass); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func useAccount(name string) error {
+	s, err := openAccount(name)
+	if err != nil {
+		return err
+	}
+	sess, current = s, name
+	return nil
+}
+
+func saveSession() error {
+	return sess.SaveSessionTo(manager.Store(current))
+}
+
+func init() {
+	Cmds["account"] = &Method{name: "account", fn: func(args ...string) (err error) {
+		if len(args) < 1 {
+			return errInvalidArgs
+		}
+		switch args[0] {
+		case "list", "ls":
+			for _, name := range accounts() {
+				mark := " "
+				if name == current {
+					mark = "*"
+				}
+				fmt.Printf("%s %s\n", mark, name)
+			}
+		case "add":
+			if len(args) != 4 || args[1] == defaultAccount || strings.ContainsAny(args[1], `/\`) {
+				return errInvalidArgs
+			}
+			if err = os.MkdirAll(filepath.Join(home, "accounts"), 0700); err != nil {
+				return
+			}
+			var user *protocol.LoginResult
+			if user, err = manager.Login(context.Background(), args[1], args[2], protocol.EncryptPass(args[3])); err != nil {
+				return
+			}
+			fmt.Printf("Account %s logon as %s (%s).\n", args[1], user.Nickname, user.UserId)
+		case "use":
+			if len(args) != 2 {
+				return errInvalidArgs
+			}
+			if err = useAccount(args[1]); err != nil {
+				return
+			}
+			conf.Current = current
+			_, err = conf.save(configFileName)
+		default:
+			err = errInvalidArgs
+		}
+		return
+	}}
+	Cmds["on"] = &Method{name: "on", fn: func(args ...string) (err error) {
+		if len(args) < 2 {
+			return errInvalidArgs
+		}
+		cmd, ok := Cmds[args[1]]
+		if !ok || cmd.name == "on" || cmd.name == "account" {
+			return fmt.Errorf("unrecognised command: %s", args[1])
+		}
+		names := []string{args[0]}
+		if args[0] == "all" {
+			names = accounts()
+		}
+		defer func(s protocol.Session, name string) {
+			sess, current = s, name
+		}(sess, current)
+		for _, name := range names {
+			s, err := openAccount(name)
+			if err != nil {
+				fmt.Printf("[%s] %v\n", name, err)
+				continue
+			}
+			sess, current = s, name
+			fmt.Printf("[%s]\n", name)
+			if err = cmd.fn(args[2:]...); err != nil {
+				fmt.Println(err)
+			}
+		}
+		return
+	}}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/zyxar/xunlei/protocol"
+)
+
+// addArgs are the arguments of the add command:
+//
+//	add [--index=0,2] [--match=regexp] [--ext=mkv,srt] [--min-size=100M] [--name=name] [--class=id]
+//	    [--beans=gold,silver | --no-beans] [--preview] <link>...
+//
+// Tasks that cost beans are confirmed at a prompt unless --beans or
+// --no-beans decides.
+type addArgs struct {
+	opts    []protocol.AddOption
+	beans   protocol.AddOption
+	preview bool
+	links   []string
+}
+
+func parseAddArgs(args []string) (*addArgs, error) {
+	a := &addArgs{beans: protocol.ConfirmBeans(confirmBeans)}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") {
+			a.links = append(a.links, arg)
+			continue
+		}
+		name, value := arg[2:], ""
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+		switch name {
+		case "preview":
+			a.preview = true
+		case "name":
+			a.opts = append(a.opts, protocol.WithName(value))
+		case "no-beans":
+			a.beans = protocol.NeverSpendBeans()
+		case "beans":
+			var gold, silver int
+			if _, err := fmt.Sscanf(value, "%d,%d", &gold, &silver); err != nil {
+				if _, err = fmt.Sscanf(value, "%d", &gold); err != nil {
+					return nil, fmt.Errorf("invalid beans %q", value)
+				}
+			}
+			a.beans = protocol.SpendBeansUpTo(gold, silver)
+		case "class":
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid class %q", value)
+			}
+			a.opts = append(a.opts, protocol.InCategory(id))
+		case "index":
+			var indexes []int
+			for _, v := range strings.Split(value, ",") {
+				i, err := strconv.Atoi(strings.TrimSpace(v))
+				if err != nil {
+					return nil, fmt.Errorf("invalid index %q", v)
+				}
+				indexes = append(indexes, i)
+			}
+			a.opts = append(a.opts, protocol.SelectIndexes(indexes...))
+		case "match":
+			exp, err := regexp.Compile(`(?i)` + value)
+			if err != nil {
+				return nil, err
+			}
+			a.opts = append(a.opts, protocol.SelectMatching(exp))
+		case "ext":
+			a.opts = append(a.opts, protocol.SelectExtensions(strings.Split(value, ",")...))
+		case "min-size":
+			size, err := parseSize(value)
+			if err != nil {
+				return nil, err
+			}
+			a.opts = append(a.opts, protocol.SelectMinSize(size))
+		default:
+			return nil, fmt.Errorf("unknown flag %s", arg)
+		}
+	}
+	if len(a.links) == 0 {
+		return nil, errInvalidArgs
+	}
+	return a, nil
+}
+
+// parseSize parses a size in bytes, with an optional binary unit such as
+// "100M" or "1.5G".
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	shift := uint(0)
+	if i := strings.IndexAny(s, "KMGT"); i >= 0 && i == len(s)-1 {
+		shift = 10 * uint(strings.IndexByte("KMGT", s[i])+1)
+		s = s[:i]
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(f * float64(int64(1)<<shift)), nil
+}
+
+func printPreview(p *protocol.BtPreview) {
+	fmt.Printf("%s %s\n", p.InfoId, p.Name)
+	for i := range p.Files {
+		f := &p.Files[i]
+		mark := " "
+		if f.Selected {
+			mark = "*"
+		}
+		fmt.Printf("%s #%d %s %d\n", mark, f.Index, f.Name, f.Size)
+	}
+	fmt.Printf("%d of %d files selected, %d bytes\n", len(p.Selected()), len(p.Files), p.SelectedSize())
+}
+
+// readLinks reads the links in file, one per line; blank lines and lines
+// starting with # are skipped.
+func readLinks(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var links []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			links = append(links, line)
+		}
+	}
+	return links, sc.Err()
+}
+
+func printChecks(cs []protocol.URLCheck) {
+	var size int64
+	var gold, silver, existed, failed int
+	for i := range cs {
+		c := &cs[i]
+		switch {
+		case c.Err != nil:
+			failed++
+			fmt.Printf("#%d [error]   %s: %v\n", i, c.URL, c.Err)
+			continue
+		case c.Exists:
+			existed++
+			fmt.Printf("#%d [exists]  %s %d\n", i, c.Name, c.Size)
+			continue
+		case c.NeedsBean():
+			fmt.Printf("#%d [bean %d:%d] %s %d\n", i, c.Goldbean, c.Silverbean, c.Name, c.Size)
+		default:
+			fmt.Printf("#%d [new]     %s %d\n", i, c.Name, c.Size)
+		}
+		size += c.Size
+		gold += c.Goldbean
+		silver += c.Silverbean
+	}
+	fmt.Printf("%d new, %d existing, %d failed; %d bytes to add, %d gold and %d silver beans\n",
+		len(cs)-existed-failed, existed, failed, size, gold, silver)
+}
+
+// options returns the options to add tasks with.
+func (a *addArgs) options() []protocol.AddOption {
+	return append(a.opts[:len(a.opts):len(a.opts)], a.beans)
+}
+
+// batchLinks splits the links into those that can be submitted in one batch,
+// ordinary and ed2k links that are checked to be new and free to add when no
+// option is given, and the rest, which are added one by one so that the bean
+// policy applies to them.
+func (a *addArgs) batchLinks() (batch, rest []string) {
+	var plain []string
+	for _, link := range a.links {
+		if len(a.opts) == 0 && isPlainLink(link) {
+			plain = append(plain, link)
+		} else {
+			rest = append(rest, link)
+		}
+	}
+	if len(plain) < 2 {
+		return nil, append(rest, plain...)
+	}
+	cs, err := sess.CheckURLs(plain)
+	if err != nil {
+		return nil, append(rest, plain...)
+	}
+	for i := range cs {
+		if c := &cs[i]; c.Err == nil && !c.Exists && !c.NeedsBean() {
+			batch = append(batch, c.URL)
+		} else {
+			rest = append(rest, c.URL)
+		}
+	}
+	return
+}
+
+func isPlainLink(link string) bool {
+	for _, scheme := range []string{"http://", "https://", "ftp://", "ed2k://"} {
+		if strings.HasPrefix(link, scheme) && !strings.HasSuffix(link, ".torrent") {
+			return true
+		}
+	}
+	return false
+}
+
+func printBatchResults(rs []protocol.BatchResult) {
+	var added, existed, failed int
+	for i := range rs {
+		r := &rs[i]
+		switch {
+		case r.Err == nil:
+			added++
+			fmt.Printf("#%d [added]  %s %s\n", i, r.TaskId, r.URL)
+		case errors.Is(r.Err, protocol.ErrTaskExisted):
+			existed++
+			fmt.Printf("#%d [exists] %s\n", i, r.URL)
+		default:
+			failed++
+			fmt.Printf("#%d [failed] %s: %v\n", i, r.URL, r.Err)
+		}
+	}
+	fmt.Printf("%d added, %d existing, %d failed\n", added, existed, failed)
+}
+
+// confirmBeans asks whether to add a task that costs beans, showing the
+// beans left in the account.
+func confirmBeans(c protocol.BeanCost) bool {
+	gold, silver := "?", "?"
+	if ua := sess.Account(); ua != nil {
+		gold, silver = ua.GoldbeanNum, ua.SilverbeanNum
+	}
+	answer, err := term.Ask(fmt.Sprintf("%s costs %d gold and %d silver beans (%s gold and %s silver left), add it? [y/N] ",
+		c.Name, c.Goldbean, c.Silverbean, gold, silver))
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -32,19 +32,24 @@ var (
 
 var Cmds = map[string]*Method{
 	"ison": &Method{name: "ison", fn: func(args ...string) (err error) {
-		fmt.Println(protocol.IsOn())
+		fmt.Println(sess.IsOn())
 		return
 	}},
 	"me": &Method{name: "me", fn: func(args ...string) (err error) {
-		fmt.Printf("%#v\n", *protocol.GetAccount())
+		fmt.Printf("%#v\n", *sess.Account())
 		return
 	}},
 	"relogin": &Method{name: "relogin", fn: func(args ...string) (err error) {
-		if !protocol.IsOn() {
-			if err = protocol.Login(conf.Id, conf.Pass); err != nil {
+		if !sess.IsOn() {
+			if current != defaultAccount {
+				return fmt.Errorf("account %s logs in again on its next request", current)
+			}
+			var user *protocol.LoginResult
+			if user, err = sess.Login(conf.Id, conf.Pass); err != nil {
 				return
 			}
-			err = protocol.SaveSession(cookieFile)
+			fmt.Printf("Logon as %s (%s).\n", user.Nickname, user.UserId)
+			err = saveSession()
 			return
 		}
 		fmt.Println("Already logon.")
@@ -53,10 +58,11 @@ var Cmds = map[string]*Method{
 	"saveconf": &Method{name: "saveconf", fn: func(args ...string) (err error) {
 		conf.Pass = protocol.EncryptPass(conf.Pass)
 		b, err := conf.save(configFileName)
-		if err == nil {
-			fmt.Printf("%s\n", b)
+		if err != nil {
+			return
 		}
-		return
+		fmt.Printf("%s\n", b)
+		return saveSession()
 	}},
 	"loadconf": &Method{name: "loadconf", fn: func(args ...string) (err error) {
 		if _, err = conf.load(configFileName); err == nil {
@@ -65,13 +71,13 @@ var Cmds = map[string]*Method{
 		return
 	}},
 	"savesession": &Method{name: "savesession", fn: func(args ...string) (err error) {
-		if err = protocol.SaveSession(cookieFile); err == nil {
+		if err = saveSession(); err == nil {
 			fmt.Println("[done]")
 		}
 		return
 	}},
 	"ls": &Method{name: "ls", fn: func(args ...string) (err error) {
-		ts, err := protocol.GetTasks()
+		ts, err := sess.GetTasks()
 		if err != nil {
 			return
 		}
@@ -83,7 +89,7 @@ var Cmds = map[string]*Method{
 		return
 	}},
 	"ld": &Method{name: "ld", fn: func(args ...string) (err error) {
-		ts, err := protocol.GetDeletedTasks()
+		ts, err := sess.GetDeletedTasks()
 		if err != nil {
 			return
 		}
@@ -95,7 +101,7 @@ var Cmds = map[string]*Method{
 		return
 	}},
 	"le": &Method{name: "le", fn: func(args ...string) (err error) {
-		ts, err := protocol.GetExpiredTasks()
+		ts, err := sess.GetExpiredTasks()
 		if err != nil {
 			return
 		}
@@ -107,7 +113,7 @@ var Cmds = map[string]*Method{
 		return
 	}},
 	"lc": &Method{name: "lc", fn: func(args ...string) (err error) {
-		ts, err := protocol.GetCompletedTasks()
+		ts, err := sess.GetCompletedTasks()
 		if err != nil {
 			return
 		}
@@ -119,7 +125,7 @@ var Cmds = map[string]*Method{
 		return
 	}},
 	"ll": &Method{name: "ll", fn: func(args ...string) (err error) {
-		ts, err := protocol.GetTasks()
+		ts, err := sess.GetTasks()
 		if err != nil {
 			return
 		}
@@ -138,7 +144,7 @@ var Cmds = map[string]*Method{
 				num = 10
 			}
 		}
-		ts, err := protocol.GetTasks(num)
+		ts, err := sess.GetTasks(num)
 		if err != nil {
 			return
 		}
@@ -162,17 +168,17 @@ var Cmds = map[string]*Method{
 		}
 		switch args[0] {
 		case "normal":
-			protocol.InvalidateCache(0)
+			sess.InvalidateCache(0)
 		case "deleted":
-			protocol.InvalidateCache(1)
+			sess.InvalidateCache(1)
 		case "purged":
-			protocol.InvalidateCache(2)
+			sess.InvalidateCache(2)
 		case "invalid":
-			protocol.InvalidateCache(3)
+			sess.InvalidateCache(3)
 		case "expired":
-			protocol.InvalidateCache(4)
+			sess.InvalidateCache(4)
 		case "all":
-			protocol.InvalidateCacheAll()
+			sess.InvalidateCacheAll()
 		}
 		return
 	}},
@@ -188,7 +194,7 @@ var Cmds = map[string]*Method{
 		j := 0
 		for i := range ts {
 			if ts[i].IsBt() {
-				m, err := protocol.FillBtList(ts[i])
+				m, err := sess.FillBtList(ts[i])
 				fmt.Printf("#%d %v\n", j, ts[i].Repr())
 				if err == nil {
 					fmt.Printf("%v\n", m)
@@ -276,7 +282,7 @@ var Cmds = map[string]*Method{
 					fmt.Println(err)
 					continue
 				}
-				fmt.Printf("Task verified? %v\n", protocol.VerifyTask(ts[i], ts[i].TaskName))
+				fmt.Printf("Task verified? %v\n", sess.VerifyTask(ts[i], ts[i].TaskName))
 			}
 			err = nil
 		}
@@ -326,7 +332,7 @@ var Cmds = map[string]*Method{
 			if err = download(pay[i].t, pay[i].s, true, check, dl); err != nil {
 				fmt.Println(err)
 			} else if del {
-				if err = protocol.DeleteTask(pay[i].t); err != nil {
+				if err = sess.DeleteTask(pay[i].t); err != nil {
 					fmt.Println(err)
 				}
 			}
@@ -340,7 +346,7 @@ var Cmds = map[string]*Method{
 			if err == nil { // TODO: improve find query
 				for i := range ts {
 					if ts[i].IsBt() {
-						if err = protocol.GetTorrentFileByHash(ts[i].Cid, ts[i].TaskName+".torrent"); err != nil {
+						if err = sess.GetTorrentFileByHash(ts[i].Cid, ts[i].TaskName+".torrent"); err != nil {
 							fmt.Println(err)
 						}
 					}
@@ -358,7 +364,7 @@ var Cmds = map[string]*Method{
 			if err == nil { // TODO: improve find query
 				for i := range ts {
 					if ts[i].IsBt() {
-						if b, err := protocol.GetTorrentByHash(ts[i].Cid); err != nil {
+						if b, err := sess.GetTorrentByHash(ts[i].Cid); err != nil {
 							fmt.Println(err)
 						} else {
 							if m, err := taipei.DecodeMetaInfo(b); err != nil {
@@ -378,16 +384,61 @@ var Cmds = map[string]*Method{
 		return
 	}},
 	"add": &Method{name: "add", fn: func(args ...string) (err error) {
-		if len(args) < 1 {
-			err = errInvalidArgs
+		a, err := parseAddArgs(args)
+		if err != nil {
 			return
 		}
+		if a.preview {
+			for _, link := range a.links {
+				var p *protocol.BtPreview
+				if p, err = sess.PreviewBtTask(link, a.options()...); err == nil {
+					printPreview(p)
+				} else {
+					fmt.Println(err)
+				}
+			}
+			return nil
+		}
+		var rs []protocol.BatchResult
+		links := a.links
+		if batch, rest := a.batchLinks(); len(batch) > 1 {
+			if rs, err = sess.AddBatchTasks(batch); err != nil {
+				return
+			}
+			for i := range rs {
+				// links that cost beans are added one by one, as the beans are confirmed
+				if errors.Is(rs[i].Err, protocol.ErrNeedBean) {
+					rs[i].Err = sess.AddTask(rs[i].URL, a.options()...)
+				}
+			}
+			links = rest
+		}
+		for _, link := range links {
+			rs = append(rs, protocol.BatchResult{URL: link, Err: sess.AddTask(link, a.options()...)})
+		}
+		printBatchResults(rs)
+		return
+	}},
+	"check": &Method{name: "check", fn: func(args ...string) (err error) {
+		var links []string
 		for i := range args {
-			if err = protocol.AddTask(args[i]); err != nil {
-				fmt.Println(err)
+			if strings.HasPrefix(args[i], "--file=") {
+				var ls []string
+				if ls, err = readLinks(strings.TrimPrefix(args[i], "--file=")); err != nil {
+					return
+				}
+				links = append(links, ls...)
+			} else {
+				links = append(links, args[i])
 			}
 		}
-		err = nil
+		if len(links) == 0 {
+			return errInvalidArgs
+		}
+		var cs []protocol.URLCheck
+		if cs, err = sess.CheckURLs(links); err == nil {
+			printChecks(cs)
+		}
 		return
 	}},
 	"rm": &Method{name: "rm", fn: func(args ...string) (err error) {
@@ -398,7 +449,7 @@ var Cmds = map[string]*Method{
 		ts, err := find(args)
 		if err == nil {
 			for i := range ts {
-				if err = protocol.DeleteTask(ts[i]); err != nil {
+				if err = sess.DeleteTask(ts[i]); err != nil {
 					fmt.Println(err)
 				}
 			}
@@ -414,7 +465,7 @@ var Cmds = map[string]*Method{
 		ts, err := find(args)
 		if err == nil {
 			for i := range ts {
-				if err = protocol.PurgeTask(ts[i]); err != nil {
+				if err = sess.PurgeTask(ts[i]); err != nil {
 					fmt.Println(err)
 				}
 			}
@@ -426,7 +477,7 @@ var Cmds = map[string]*Method{
 		if len(args) > 0 {
 			ts, err := find(args)
 			if err == nil {
-				protocol.ReAddTasks(ts)
+				printBatchResults(sess.ReAddTasks(ts))
 			}
 		} else {
 			err = errInvalidArgs
@@ -434,7 +485,7 @@ var Cmds = map[string]*Method{
 		return
 	}},
 	"delayall": &Method{name: "delayall", fn: func(args ...string) (err error) {
-		err = protocol.DelayAllTasks()
+		err = sess.DelayAllTasks()
 		return
 	}},
 	"pause": &Method{name: "pause", fn: func(args ...string) (err error) {
@@ -442,7 +493,7 @@ var Cmds = map[string]*Method{
 			ts, err := find(args)
 			if err == nil {
 				for i := range ts {
-					if err = protocol.PauseTask(ts[i]); err != nil {
+					if err = sess.PauseTask(ts[i]); err != nil {
 						fmt.Println(err)
 					}
 				}
@@ -458,7 +509,7 @@ var Cmds = map[string]*Method{
 			ts, err := find(args)
 			if err == nil {
 				for i := range ts {
-					if err = protocol.ResumeTask(ts[i]); err != nil {
+					if err = sess.ResumeTask(ts[i]); err != nil {
 						fmt.Println(err)
 					}
 				}
@@ -472,8 +523,8 @@ var Cmds = map[string]*Method{
 	"rename": &Method{name: "rename", fn: func(args ...string) (err error) {
 		if len(args) > 2 {
 			// must be task id here
-			if t, ok := protocol.GetTaskById(args[0]); ok {
-				err = protocol.RenameTask(t, strings.Join(args[1:], " "))
+			if t, ok := sess.GetTaskById(args[0]); ok {
+				err = sess.RenameTask(t, strings.Join(args[1:], " "))
 			} else {
 				err = errTaskNotFound
 			}
@@ -489,7 +540,7 @@ var Cmds = map[string]*Method{
 			ts, err := find(args)
 			if err == nil {
 				for i := range ts {
-					if err = protocol.DelayTask(ts[i]); err != nil {
+					if err = sess.DelayTask(ts[i]); err != nil {
 						fmt.Println(err)
 					}
 				}
@@ -507,11 +558,11 @@ var Cmds = map[string]*Method{
 					if !ts[i].IsBt() {
 						fmt.Printf("#%d %s: %v\n", k, ts[i].Id, ts[i].LixianURL)
 					} else {
-						m, err := protocol.FillBtList(ts[i])
+						m, err := sess.FillBtList(ts[i])
 						if err == nil {
 							fmt.Printf("#%d %s:\n", k, ts[i].Id)
-							for j := range m.Record {
-								fmt.Printf("  #%d %s\n", j, m.Record[j].DownURL)
+							for j := range m.Files {
+								fmt.Printf("  #%d %s\n", j, m.Files[j].DownURL)
 							}
 						} else {
 							fmt.Println(err)
@@ -541,8 +592,104 @@ var Cmds = map[string]*Method{
 		}
 		return
 	}},
+	"play": &Method{name: "play", fn: func(args ...string) (err error) {
+		printOnly := len(conf.Player) == 0
+		var reqs []string
+		for i := range args {
+			if args[i] == "--print" {
+				printOnly = true
+			} else {
+				reqs = append(reqs, args[i])
+			}
+		}
+		if len(reqs) == 0 {
+			return errInvalidArgs
+		}
+		for _, req := range reqs {
+			p := strings.SplitN(req, "/", 2)
+			filter := `.*`
+			if len(p) == 2 {
+				filter = p[1]
+			}
+			m, err := query(p[0])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			for i := range m {
+				ps, err := playables(m[i], filter)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				for j := range ps {
+					if printOnly || len(ps[j].urls) == 0 {
+						printPlayable(&ps[j])
+					} else if err = play(conf.Player, ps[j].best()); err != nil {
+						fmt.Println(err)
+					}
+				}
+			}
+		}
+		return
+	}},
+	"class": &Method{name: "class", fn: func(args ...string) (err error) {
+		if len(args) == 0 {
+			args = []string{"ls"}
+		}
+		switch args[0] {
+		case "ls":
+			var cs []protocol.Category
+			if cs, err = sess.GetCategories(); err == nil {
+				for i := range cs {
+					fmt.Printf("#%d %s (%d)\n", cs[i].Id, cs[i].Name, cs[i].Count)
+				}
+			}
+		case "add":
+			if len(args) < 2 {
+				return errInvalidArgs
+			}
+			var c *protocol.Category
+			if c, err = sess.AddCategory(strings.Join(args[1:], " ")); err == nil {
+				fmt.Printf("#%d %s\n", c.Id, c.Name)
+			}
+		case "mv":
+			if len(args) < 3 {
+				return errInvalidArgs
+			}
+			var id int
+			if id, err = strconv.Atoi(args[1]); err != nil {
+				return errInvalidArgs
+			}
+			var ts map[string]*protocol.Task
+			if ts, err = find(args[2:]); err == nil {
+				ids := make([]string, 0, len(ts))
+				for i := range ts {
+					ids = append(ids, ts[i].Id)
+				}
+				err = sess.MoveTasks(ids, id)
+			}
+		case "show":
+			if len(args) < 2 {
+				return errInvalidArgs
+			}
+			var id int
+			if id, err = strconv.Atoi(args[1]); err != nil {
+				return errInvalidArgs
+			}
+			var ts []*protocol.Task
+			if ts, err = sess.GetCategoryTasks(id); err == nil {
+				for i := range ts {
+					fmt.Printf("#%d %v\n", i, ts[i].Coloring())
+				}
+			}
+		default:
+			err = errInvalidArgs
+		}
+		return
+	}},
 	"update": &Method{name: "update", fn: func(args ...string) (err error) {
-		err = protocol.ProcessTask(func(t *protocol.Task) error {
+		err = sess.ProcessTask(func(t *protocol.Task) error {
 			fmt.Printf("%s %s %sB/s %.2f%%\n", t.Id, fixedLengthName(t.TaskName, 32), t.Speed, t.Progress)
 			return nil
 		})
@@ -11,8 +11,10 @@ import (
 
 type configure struct {
 	Id        string `json:"account"`
-	Pass      string `json:"password"`
+	Pass      string `json:"password,omitempty"` // read from older configs; now saved with the session
 	CheckHash bool   `json:"check_hash"`
+	Current   string `json:"current_account,omitempty"`
+	Player    string `json:"player,omitempty"` // command line streaming urls are handed to by play
 }
 
 var (
@@ -27,14 +29,22 @@ var (
 const (
 	version    = "master"
 	binaryName = "github.com/zyxar/xunlei/lx"
+	prompt     = "lixian >> "
 )
 
+// save writes the configuration without the password,
+// which is saved along with the session of the default account.
 func (id *configure) save(cf string) (b []byte, err error) {
-	b, err = json.MarshalIndent(id, "", "  ")
+	c := *id
+	c.Pass = ""
+	b, err = json.MarshalIndent(&c, "", "  ")
 	if err != nil {
 		return
 	}
-	err = ioutil.WriteFile(cf, b, 0644)
+	if err = ioutil.WriteFile(cf, b, 0600); err != nil {
+		return
+	}
+	err = os.Chmod(cf, 0600)
 	return
 }
 
@@ -69,6 +79,9 @@ func initConf() {
 	cookieFile = filepath.Join(home, "cookie.json")
 	conf.CheckHash = true
 	conf.load(configFileName)
+	if state, err := accountStore(defaultAccount).Load(); err == nil && len(conf.Id) == 0 {
+		conf.Id, conf.Pass = state.Account, state.Passhash
+	}
 }
 
 func mkConfigDir() (err error) {
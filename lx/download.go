@@ -15,7 +15,7 @@ var worker = fetch.DefaultFetcher
 type taskSink func(uri, filename string, echo bool) error
 
 func dl(uri, filename string, echo bool) error { //TODO: check file existence
-	gid, err := protocol.GetGdriveId()
+	gid, err := sess.GetGdriveId()
 	if err != nil {
 		return err
 	}
@@ -27,28 +27,29 @@ func dl(uri, filename string, echo bool) error { //TODO: check file existence
 
 func download(t *protocol.Task, filter string, echo, verify bool, sink taskSink) error {
 	if t.IsBt() {
-		m, err := protocol.FillBtList(t)
+		m, err := sess.FillBtList(t)
 		if err != nil {
 			return err
 		}
 		var fullpath string
-		for j := range m.Record {
-			if m.Record[j].Status == "2" {
-				if ok, _ := regexp.MatchString(`(?i)`+filter, m.Record[j].FileName); ok {
-					fmt.Println("Downloading", m.Record[j].FileName, "...")
-					if len(m.Record) == 1 { // choose not to use torrent info, to reduce network transportation
-						fullpath = m.Record[j].FileName
+		for j := range m.Files {
+			f := &m.Files[j]
+			if f.Completed() {
+				if ok, _ := regexp.MatchString(`(?i)`+filter, f.Name); ok {
+					fmt.Println("Downloading", f.Name, "...")
+					if len(m.Files) == 1 { // choose not to use torrent info, to reduce network transportation
+						fullpath = f.Name
 					} else {
-						fullpath = filepath.Join(t.TaskName, m.Record[j].FileName)
+						fullpath = filepath.Join(t.TaskName, f.Name)
 					}
-					if err = sink(m.Record[j].DownURL, fullpath, echo); err != nil {
+					if err = sink(f.DownURL, fullpath, echo); err != nil {
 						return err
 					}
 				} else {
-					fmt.Printf("Skip unselected task %s\n", m.Record[j].FileName)
+					fmt.Printf("Skip unselected task %s\n", f.Name)
 				}
 			} else {
-				fmt.Printf("Skip incompleted task %s\n", m.Record[j].FileName)
+				fmt.Printf("Skip incompleted task %s\n", f.Name)
 			}
 		}
 	} else {
@@ -60,7 +61,7 @@ func download(t *protocol.Task, filter string, echo, verify bool, sink taskSink)
 			return err
 		}
 	}
-	if verify && !protocol.VerifyTask(t, t.TaskName) {
+	if verify && !sess.VerifyTask(t, t.TaskName) {
 		return errors.New("Verification failed.")
 	}
 	return nil
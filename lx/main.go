@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/signal"
 	"regexp"
@@ -17,14 +18,21 @@ import (
 )
 
 type Term interface {
+	io.Writer // writes above the line being edited
 	ReadLine() (string, error)
+	// Ask reads the answer to question in place of the next command.
+	Ask(question string) (string, error)
 	Restore()
 }
 
+var term Term
+
 func main() {
 	initConf()
 	printVer := flag.Bool("version", false, "print version")
 	debug := flag.Bool("debug", false, "set log level to debug")
+	record := flag.String("record", "", "record HTTP traffic to a cassette file")
+	replay := flag.String("replay", "", "replay HTTP traffic from a cassette file")
 	flag.Parse()
 	if *printVer {
 		printVersion()
@@ -33,20 +41,29 @@ func main() {
 	if *debug {
 		log.SetLevel(log.DebugLevel)
 	}
+	var transport http.RoundTripper
 	var err error
-	if err = protocol.ResumeSession(cookieFile); err != nil {
+	if *replay != "" {
+		transport, err = protocol.NewReplayer(*replay)
+	} else if *record != "" {
+		transport, err = protocol.NewRecorder(*record, nil)
+	}
+	if err != nil {
 		log.Warn(err.Error())
-		if err = protocol.Login(conf.Id, conf.Pass); err != nil {
-			log.Warn(err.Error())
-			os.Exit(1)
-		}
-		if err = protocol.SaveSession(cookieFile); err != nil {
-			log.Warn(err.Error())
-		}
+		os.Exit(1)
+	}
+	initAccounts(transport)
+	account := conf.Current
+	if account == "" {
+		account = defaultAccount
+	}
+	if err = useAccount(account); err != nil {
+		log.Warn(err.Error())
+		os.Exit(1)
 	}
 
-	protocol.GetGdriveId()
-	term := newTerm()
+	sess.GetGdriveId()
+	term = newTerm()
 	var quit = func(code int) {
 		term.Restore()
 		os.Exit(code)
@@ -96,17 +113,16 @@ func main() {
 			fmt.Println(err)
 		}
 	}
-	term.Restore()
 }
 
 func query(req string) (map[string]*protocol.Task, error) {
-	if t, ok := protocol.GetTaskById(req); ok {
+	if t, ok := sess.GetTaskById(req); ok {
 		return map[string]*protocol.Task{req: t}, nil
 	}
 	if ok, _ := regexp.MatchString(`(.+=.+)+`, req); ok {
-		return protocol.FindTasks(req)
+		return sess.FindTasks(req)
 	}
-	return protocol.FindTasks("name=" + req)
+	return sess.FindTasks("name=" + req)
 }
 
 func find(req []string) (map[string]*protocol.Task, error) {
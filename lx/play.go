@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/zyxar/xunlei/protocol"
+)
+
+// playable is a completed task or bt file along with its streaming renditions.
+type playable struct {
+	name string
+	urls []protocol.PlayURL
+}
+
+// playables lists the renditions of t, or of those of its completed bt files
+// whose name matches filter.
+func playables(t *protocol.Task, filter string) ([]playable, error) {
+	if !t.IsBt() {
+		us, err := sess.GetPlayURLs(t)
+		if err != nil {
+			return nil, err
+		}
+		return []playable{{t.TaskName, us}}, nil
+	}
+	exp, err := regexp.Compile(`(?i)` + filter)
+	if err != nil {
+		return nil, err
+	}
+	m, err := sess.FillBtList(t)
+	if err != nil {
+		return nil, err
+	}
+	var ps []playable
+	for _, f := range m.Filter(func(f *protocol.BtFile) bool { return f.Completed() && exp.MatchString(f.Name) }) {
+		us, err := sess.GetBtFilePlayURLs(f)
+		if err != nil {
+			fmt.Printf("%s: %v\n", f.Name, err)
+			continue
+		}
+		ps = append(ps, playable{f.Name, us})
+	}
+	return ps, nil
+}
+
+// play runs the player command line, with %u and %c in its arguments
+// replaced by the url and the cookie of u; the url is appended if no
+// argument has %u.
+func play(player string, u protocol.PlayURL) error {
+	args := strings.Fields(player)
+	if len(args) == 0 {
+		return errInvalidArgs
+	}
+	hasURL := false
+	for i := range args {
+		if strings.Contains(args[i], "%u") {
+			hasURL = true
+		}
+		args[i] = strings.NewReplacer("%u", u.URL, "%c", u.Cookie).Replace(args[i])
+	}
+	if !hasURL {
+		args = append(args, u.URL)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// best returns the rendition of p of the highest resolution.
+func (p *playable) best() protocol.PlayURL {
+	var b protocol.PlayURL
+	max := -1
+	for _, u := range p.urls {
+		var w, h int
+		fmt.Sscanf(u.Resolution, "%dx%d", &w, &h)
+		if w*h > max {
+			b, max = u, w*h
+		}
+	}
+	return b
+}
+
+func printPlayable(p *playable) {
+	fmt.Println(p.name)
+	for i := range p.urls {
+		u := &p.urls[i]
+		fmt.Printf("  #%d %s %s\n", i, u.Resolution, u.URL)
+	}
+	if len(p.urls) > 0 {
+		fmt.Printf("  Cookie: %s\n", p.urls[0].Cookie)
+	}
+}
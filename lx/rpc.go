@@ -5,7 +5,6 @@ import (
 	"fmt"
 
 	"github.com/zyxar/argo/rpc"
-	"github.com/zyxar/xunlei/protocol"
 )
 
 var rpcc, _ = rpc.New("http://localhost:6800/jsonrpc")
@@ -28,7 +27,7 @@ func newOpt(gdriveid, filename string) (rpc.Option, error) {
 }
 
 func rpcAddTask(uri, filename string) (string, error) {
-	gid, err := protocol.GetGdriveId()
+	gid, err := sess.GetGdriveId()
 	if err != nil {
 		return "", err
 	}
@@ -30,7 +30,7 @@ func newTerm() Term {
 	if err != nil {
 		panic(err)
 	}
-	u.t = terminal.NewTerminal(os.Stdin, "lixian >> ")
+	u.t = terminal.NewTerminal(os.Stdin, prompt)
 	return u
 }
 
@@ -41,3 +41,13 @@ func (u *uterm) Restore() {
 func (u *uterm) ReadLine() (string, error) {
 	return u.t.ReadLine()
 }
+
+func (u *uterm) Write(b []byte) (int, error) {
+	return u.t.Write(b)
+}
+
+func (u *uterm) Ask(question string) (string, error) {
+	u.t.SetPrompt(question)
+	defer u.t.SetPrompt(prompt)
+	return u.t.ReadLine()
+}
@@ -30,7 +30,7 @@ func (*wterm) Write(b []byte) (int, error) {
 
 func newTerm() Term {
 	w := new(wterm)
-	w.t = terminal.NewTerminal(w, "lixian >> ")
+	w.t = terminal.NewTerminal(w, prompt)
 	return w
 }
 
@@ -38,5 +38,11 @@ func (w *wterm) ReadLine() (string, error) {
 	return w.t.ReadLine()
 }
 
+func (w *wterm) Ask(question string) (string, error) {
+	w.t.SetPrompt(question)
+	defer w.t.SetPrompt(prompt)
+	return w.t.ReadLine()
+}
+
 func (w *wterm) Restore() {
 }
@@ -63,7 +63,7 @@ func DelayTaskAsync(id string, callback func(error)) {
 	}()
 }
 
-func FillBtListAsync(taskid, infohash string, callback func(*btList, error)) {
+func FillBtListAsync(taskid, infohash string, callback func(*BtList, error)) {
 	go func() {
 		l, err := defaultSession.FillBtListById(taskid, infohash)
 		if callback != nil {
@@ -81,11 +81,11 @@ func AddTaskAsync(req string, callback func(error)) {
 	}()
 }
 
-func AddBatchTasksAsync(req []string, callback func(error), oids ...string) {
+func AddBatchTasksAsync(req []string, callback func([]BatchResult, error), oids ...string) {
 	go func() {
-		err := defaultSession.AddBatchTasks(req, oids...)
+		rs, err := defaultSession.AddBatchTasks(req, oids...)
 		if callback != nil {
-			callback(err)
+			callback(rs, err)
 		}
 	}()
 }
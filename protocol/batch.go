@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+)
+
+// BatchResult is the outcome of submitting one url in a batch.
+type BatchResult struct {
+	URL    string
+	TaskId string // of the new or re-added task, if reported
+	Err    error  // ErrTaskExisted for duplicates, an *APIError if rejected
+}
+
+// batchCommitReply is the reply of batch_task_commit:
+//
+//	jsonp1457357084473({"progress":1,"rtcode":0,"list":[{"url":"http://...","id":"1234","result":1},{"url":"http://...","id":"","result":-1,"msg":"任务已存在"}]})
+type batchCommitReply struct {
+	*ErrorMessage
+	Progress int `json:"progress"`
+	List     []struct {
+		URL    string `json:"url"`
+		Id     string `json:"id"`
+		Result int    `json:"result"`
+		Msg    string `json:"msg"`
+	} `json:"list"`
+}
+
+// AddBatchTasksContext submits urls in one request, re-adding the deleted
+// tasks oids if given, and returns the outcome of each url in order. The
+// error is only set when the batch as a whole fails.
+func (s *session) AddBatchTasksContext(ctx context.Context, urls []string, oids ...string) ([]BatchResult, error) {
+	// TODO: filter urls
+	v := url.Values{}
+	for i := 0; i < len(urls); i++ {
+		v.Add("cid[]", "")
+		v.Add("url[]", url.QueryEscape(urls[i]))
+	}
+	v.Add("class_id", "0")
+	if len(oids) > 0 {
+		var b bytes.Buffer
+		for i := 0; i < len(oids); i++ {
+			b.WriteString("0,")
+		}
+		v.Add("batch_old_taskid", strings.Join(oids, ","))
+		v.Add("batch_old_database", b.String())
+		v.Add("interfrom", "history")
+	} else {
+		v.Add("batch_old_taskid", "0,")
+		v.Add("batch_old_database", "0,")
+		v.Add("interfrom", "task")
+	}
+	tm := currentTimestamp()
+	r, err := s.commitForm(ctx, s.lixianURL(batchtaskcommitURI, tm, tm), v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("batch submission response: %s", r)
+	return parseBatchCommit(r, urls)
+}
+
+// parseBatchCommit matches the outcomes in the batch_task_commit reply r to
+// urls. A url the reply does not mention fails with ErrUnexpected, as whether
+// it was added is unknown.
+func parseBatchCommit(r []byte, urls []string) ([]BatchResult, error) {
+	c, err := parseCall("batch_task_commit", r, "jsonp")
+	if err != nil {
+		return nil, err
+	}
+	var reply batchCommitReply
+	if err = c.Decode(0, &reply); err != nil {
+		return nil, malformedResponse("batch_task_commit", r, err)
+	}
+	if code := reply.Rtcode(); (code != "" && code != "0") || reply.Progress != 1 {
+		return nil, newAPIError("batch_task_commit", code, reply.Msg(), r, ErrTaskSubmissionFailed)
+	}
+	rs := make([]BatchResult, len(urls))
+	matched := make([]bool, len(urls))
+	for i := range urls {
+		rs[i].URL = urls[i]
+		rs[i].Err = &APIError{Endpoint: "batch_task_commit", Message: "url not reported", Body: r, Err: ErrUnexpected}
+	}
+	for _, item := range reply.List {
+		i := 0
+		for ; i < len(urls) && (matched[i] || urls[i] != item.URL); i++ {
+		}
+		if i == len(urls) {
+			continue
+		}
+		matched[i] = true
+		switch item.Result {
+		case 1:
+			rs[i].TaskId, rs[i].Err = item.Id, nil
+		case -1:
+			rs[i].Err = newAPIError("batch_task_commit", "-1", item.Msg, r, ErrTaskExisted)
+		default:
+			rs[i].Err = newAPIError("batch_task_commit", strconv.Itoa(item.Result), item.Msg, r, ErrTaskSubmissionFailed)
+		}
+	}
+	return rs, nil
+}
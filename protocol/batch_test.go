@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zyxar/xunlei/protocol/xltest"
+)
+
+func TestBatchTasks(t *testing.T) {
+	s, srv := newTestSession(t)
+	defer srv.Close()
+	old := srv.AddTask(xltest.Task{Name: "old.iso", URL: "http://example.com/old.iso", Size: 1 << 20, Type: xltest.TypeOrdinary})
+	srv.SetCost("http://example.com/gold.iso", 1, 0)
+	urls := []string{"http://example.com/a.iso", "http://example.com/old.iso", "http://example.com/gold.iso", "http://example.com/b.iso"}
+	rs, err := s.AddBatchTasks(urls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(rs))
+	}
+	for i, r := range rs {
+		if r.URL != urls[i] {
+			t.Errorf("expected result #%d for %s, got %s", i, urls[i], r.URL)
+		}
+	}
+	if rs[0].Err != nil || rs[0].TaskId == "" || rs[3].Err != nil || rs[3].TaskId == "" {
+		t.Errorf("expected new tasks, got %+v", rs)
+	}
+	if !errors.Is(rs[1].Err, ErrTaskExisted) || !errors.Is(rs[2].Err, ErrNeedBean) {
+		t.Errorf("expected duplicate and rejected tasks, got %v, %v", rs[1].Err, rs[2].Err)
+	}
+	for _, id := range []string{old, rs[0].TaskId} {
+		srv.UpdateTask(id, func(t *xltest.Task) { t.Flag = xltest.FlagDeleted })
+	}
+	ts, err := s.GetDeletedTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.GetTasks(); err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]*Task)
+	for _, task := range ts {
+		m[task.Id] = task
+	}
+	b, ok := s.GetTaskById(rs[3].TaskId)
+	if !ok {
+		t.Fatalf("expected task %s to be cached", rs[3].TaskId)
+	}
+	m[b.Id] = b
+	rs = s.ReAddTasks(m)
+	if len(rs) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(rs))
+	}
+	readded := 0
+	for _, r := range rs {
+		switch {
+		case r.Err == nil && r.TaskId != "":
+			readded++
+		case r.URL != urls[3] || !errors.Is(r.Err, ErrTaskAlreadyQueued):
+			t.Errorf("unexpected result: %+v", r)
+		}
+	}
+	if readded != 2 {
+		t.Errorf("expected 2 tasks to be re-added, got %+v", rs)
+	}
+	if task, _ := srv.Task(old); task.Flag != xltest.FlagNormal {
+		t.Errorf("expected task to be re-added, got flag %d", task.Flag)
+	}
+
+	rs, err = parseBatchCommit([]byte(`jsonp1457357084473({"progress":1,"rtcode":0})`), urls[:1])
+	if err != nil || len(rs) != 1 || !errors.Is(rs[0].Err, ErrUnexpected) {
+		t.Errorf("expected a url missing from the reply to be unknown, got %+v, %v", rs, err)
+	}
+	for _, reply := range []string{
+		`jsonp1457357084473({"progress":2,"rtcode":"5","msg":"failed"})`,
+		`jsonp1457357084473({"progress":2})`,
+	} {
+		if _, err = parseBatchCommit([]byte(reply), urls[:1]); !errors.Is(err, ErrTaskSubmissionFailed) {
+			t.Errorf("expected ErrTaskSubmissionFailed for %s, got %v", reply, err)
+		}
+	}
+}
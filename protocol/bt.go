@@ -3,8 +3,85 @@ package protocol
 import (
 	"encoding/json"
 	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
+// BtList is the list of the files of a bt task.
+type BtList struct {
+	Id     string // task id
+	InfoId string // infohash
+	Files  []BtFile
+}
+
+// BtFile is a file of a bt task.
+type BtFile struct {
+	Index    int    // in the torrent
+	Name     string // slash-separated path in the torrent
+	Dir      string // slash-separated directory, empty at the top level
+	Size     int64  // in bytes
+	Status   TaskStatus
+	Percent  int
+	Cid      string
+	TaskId   string
+	LiveTime string
+	DownURL  string // lixian url, once completed
+	URL      string
+}
+
+// Completed reports whether f can be downloaded.
+func (f *BtFile) Completed() bool {
+	return f.Status == StatusCompleted
+}
+
+// Filter returns the files of l for which keep returns true.
+func (l *BtList) Filter(keep func(f *BtFile) bool) []*BtFile {
+	files := make([]*BtFile, 0, len(l.Files))
+	for i := range l.Files {
+		if keep(&l.Files[i]) {
+			files = append(files, &l.Files[i])
+		}
+	}
+	return files
+}
+
+// Match returns the files of l whose name matches pattern, a regular
+// expression, case-insensitively.
+func (l *BtList) Match(pattern string) ([]*BtFile, error) {
+	exp, err := regexp.Compile(`(?i)` + pattern)
+	if err != nil {
+		return nil, err
+	}
+	return l.Filter(func(f *BtFile) bool { return exp.MatchString(f.Name) }), nil
+}
+
+// Completed returns the files of l that can be downloaded.
+func (l *BtList) Completed() []*BtFile {
+	return l.Filter((*BtFile).Completed)
+}
+
+// Size returns the total size of the files of l for which keep returns
+// true, or of all files if keep is nil.
+func (l *BtList) Size(keep func(f *BtFile) bool) (size int64) {
+	for i := range l.Files {
+		if keep == nil || keep(&l.Files[i]) {
+			size += l.Files[i].Size
+		}
+	}
+	return
+}
+
+func (t BtList) String() string {
+	r := fmt.Sprintf("%s %s %d\n", t.Id, t.InfoId, len(t.Files))
+	for i := range t.Files {
+		f := &t.Files[i]
+		r += fmt.Sprintf("#%d %s %s %s\n", f.Index, f.Name, formatSize(f.Size), f.Status)
+	}
+	return r
+}
+
 type btList struct {
 	Id       string     `json:"Tid"`
 	InfoId   string     `json:"Infoid"`
@@ -30,6 +107,38 @@ type btRecord struct {
 	DirName      string `json:"dirtitle"`
 }
 
+// file returns the exported form of r.
+func (r *btRecord) file() BtFile {
+	name := unescapeName(strings.Replace(r.FileName, `\\`, `/`, -1))
+	dir := strings.Trim(unescapeName(strings.Replace(r.DirName, `\\`, `/`, -1)), "/")
+	if dir == "." {
+		dir = ""
+	}
+	f := BtFile{
+		Index:    r.Id,
+		Name:     name,
+		Dir:      dir,
+		Status:   Task{DownloadStatus: r.Status}.State(),
+		Percent:  r.Percent,
+		Cid:      r.Cid,
+		TaskId:   r.TaskId,
+		LiveTime: r.LiveTime,
+		DownURL:  r.DownURL,
+		URL:      r.URL,
+	}
+	if n, err := strconv.ParseInt(r.FileSize, 10, 64); err == nil {
+		f.Size = n
+	} else {
+		f.Size, _ = parseSize(r.SizeReadable)
+	}
+	if f.Dir == "" {
+		if d := path.Dir(name); d != "." {
+			f.Dir = d
+		}
+	}
+	return f
+}
+
 type btFileList struct {
 	Id    string `json:"id"`
 	Size  string `json:"subsize"`
@@ -73,14 +182,6 @@ func (response btQueryResponse) String() string {
 	return string(b)
 }
 
-func (t btList) String() string {
-	r := fmt.Sprintf("%s %s %s\n", t.Id, t.InfoId, t.BtNum)
-	for i := range t.Record {
-		r += fmt.Sprintf("#%d %s %s %s\n", t.Record[i].Id, t.Record[i].FileName, t.Record[i].SizeReadable, t.Record[i].Status)
-	}
-	return r
-}
-
 type ErrorMessage struct {
 	Code    json.RawMessage `json:"rtcode,omitempty"`
 	Message string          `json:"msg,omitempty"`
@@ -96,3 +197,10 @@ type btSumbissionResponse struct {
 	Progress       int             `json:"progress"`
 	*ErrorMessage
 }
+
+func (r *btSumbissionResponse) apiError(body []byte) error {
+	if r.ErrorMessage == nil {
+		return &APIError{Endpoint: "bt_task_commit", Body: body, Err: ErrTaskSubmissionFailed}
+	}
+	return newAPIError("bt_task_commit", r.Rtcode(), r.Message, body, ErrTaskSubmissionFailed)
+}
@@ -0,0 +1,72 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/zyxar/xunlei/protocol/xltest"
+)
+
+func TestBtRecordFile(t *testing.T) {
+	r := btRecord{Id: 3, FileName: `movie\\a &amp;amp; b.mkv`, Status: "2", FileSize: "1073741824", DirName: `movie\\`, Percent: 100}
+	f := r.file()
+	if f.Index != 3 || f.Name != "movie/a & b.mkv" || f.Dir != "movie" || f.Size != 1<<30 || f.Status != StatusCompleted || !f.Completed() {
+		t.Errorf("unexpected file: %+v", f)
+	}
+	r = btRecord{FileName: "c.txt", Status: "9", SizeReadable: "2.0K"}
+	if f = r.file(); f.Dir != "" || f.Size != 2048 || f.Status != StatusUnknown {
+		t.Errorf("unexpected file: %+v", f)
+	}
+}
+
+func TestBtList(t *testing.T) {
+	l := &BtList{Files: []BtFile{
+		{Index: 0, Name: "a.mkv", Size: 100, Status: StatusCompleted},
+		{Index: 1, Name: "a.srt", Size: 10, Status: StatusDownloading},
+		{Index: 2, Name: "b.MKV", Size: 200, Status: StatusCompleted},
+	}}
+	if fs := l.Completed(); len(fs) != 2 || fs[1].Index != 2 {
+		t.Errorf("unexpected completed files: %v", fs)
+	}
+	fs, err := l.Match(`\.mkv$`)
+	if err != nil || len(fs) != 2 {
+		t.Errorf("unexpected matched files: %v, %v", fs, err)
+	}
+	if _, err = l.Match("("); err == nil {
+		t.Error("expected invalid pattern")
+	}
+	if n := l.Size(nil); n != 310 {
+		t.Errorf("unexpected total size: %d", n)
+	}
+	if n := l.Size((*BtFile).Completed); n != 300 {
+		t.Errorf("unexpected completed size: %d", n)
+	}
+}
+
+func TestAddTorrent(t *testing.T) {
+	s, srv := newTestSession(t)
+	defer srv.Close()
+	hash := srv.AddTorrent("album", xltest.File{Name: "album/01.flac", Size: 1 << 25}, xltest.File{Name: "album/cover.jpg", Size: 1 << 16})
+	id, infohash, err := s.AddTorrentBytes(srv.TorrentFile(hash), WithName("renamed"), SelectExtensions("flac"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infohash != hash {
+		t.Errorf("unexpected infohash: %s", infohash)
+	}
+	task, ok := srv.Task(id)
+	if !ok || task.Name != "renamed" || len(task.Files) != 1 || task.Files[0].Name != "album/01.flac" {
+		t.Errorf("unexpected task %q: %+v", id, task)
+	}
+	if _, _, err = s.AddTorrent(bytes.NewReader(srv.TorrentFile(hash))); !errors.Is(err, ErrTaskExisted) {
+		t.Errorf("expected ErrTaskExisted, got %v", err)
+	}
+	uploads := srv.Requests("torrent_upload")
+	if _, _, err = s.AddTorrentBytes([]byte("<html>not a torrent</html>")); !errors.Is(err, ErrInvalidTorrent) {
+		t.Errorf("expected ErrInvalidTorrent, got %v", err)
+	}
+	if srv.Requests("torrent_upload") != uploads {
+		t.Error("expected invalid torrent not to be uploaded")
+	}
+}
@@ -1,17 +1,15 @@
 package protocol
 
 import (
-	"errors"
 	"net/url"
 	"regexp"
+	"strconv"
 	"sync"
 )
 
-var errInvalidQuery = errors.New("Invalid query string.")
-
 type cache struct {
 	Tasks map[string]*Task
-	sync.Mutex
+	sync.RWMutex
 }
 
 func newCache() *cache {
@@ -21,30 +19,53 @@ func newCache() *cache {
 }
 
 func (c *cache) getTaskbyId(taskid string) *Task {
+	c.RLock()
+	defer c.RUnlock()
 	return c.Tasks[taskid]
 }
 
 func (c *cache) GetTaskById(taskid string) (t *Task, exist bool) {
+	c.RLock()
 	t, exist = c.Tasks[taskid]
+	c.RUnlock()
 	return
 }
 
-// Find tasks in local cache by query: `name=xxx`, `group=g`, `status=s`, `type=t`
+func (c *cache) size() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.Tasks)
+}
+
+// snapshot returns a copy of the task map, safe to iterate while the cache is updated.
+func (c *cache) snapshot() map[string]*Task {
+	c.RLock()
+	defer c.RUnlock()
+	m := make(map[string]*Task, len(c.Tasks))
+	for k, v := range c.Tasks {
+		m[k] = v
+	}
+	return m
+}
+
+// Find tasks in local cache by query: `name=xxx`, `group=g`, `status=s`, `type=t`, `class=c`
 // name: `xxx` is considered as a regular expression.
 // group: waiting, downloading, completed, failed, pending
 // status: normal, expired, deleted, purged
 // type: bt, nbt
-// e.g. pattern == "name=abc&group=completed&status=normal&type=bt"
+// class: category id, 0 for tasks in no category
+// e.g. pattern == "name=abc&group=completed&status=normal&type=bt&class=3"
 func (c *cache) FindTasks(pattern string) (map[string]*Task, error) {
 	v, err := url.ParseQuery(pattern)
 	if err != nil {
 		return nil, err
 	}
-	var ts = c.Tasks
+	var ts = c.snapshot()
 	n := v.Get("name")
 	gg := v["group"]
 	ss := v["status"]
 	tt := v["type"]
+	cc := v["class"]
 	if len(tt) > 0 {
 		tr := make(map[string]*Task)
 		for k := range tt {
@@ -62,7 +83,7 @@ func (c *cache) FindTasks(pattern string) (map[string]*Task, error) {
 					}
 				}
 			default:
-				return nil, errInvalidQuery
+				return nil, ErrInvalidQuery
 			}
 		}
 		ts = tr
@@ -96,7 +117,7 @@ func (c *cache) FindTasks(pattern string) (map[string]*Task, error) {
 					}
 				}
 			default:
-				return nil, errInvalidQuery
+				return nil, ErrInvalidQuery
 			}
 		}
 		ts = tr
@@ -136,7 +157,22 @@ func (c *cache) FindTasks(pattern string) (map[string]*Task, error) {
 					}
 				}
 			default:
-				return nil, errInvalidQuery
+				return nil, ErrInvalidQuery
+			}
+		}
+		ts = tr
+	}
+	if len(cc) > 0 {
+		tr := make(map[string]*Task)
+		for k := range cc {
+			id, err := strconv.Atoi(cc[k])
+			if err != nil || id < 0 {
+				return nil, ErrInvalidQuery
+			}
+			for i := range ts {
+				if class, _ := strconv.Atoi(ts[i].ClassValue); class == id {
+					tr[i] = ts[i]
+				}
 			}
 		}
 		ts = tr
@@ -144,7 +180,7 @@ func (c *cache) FindTasks(pattern string) (map[string]*Task, error) {
 	if len(n) > 0 {
 		exp, err := regexp.Compile(`(?i)` + n)
 		if err != nil {
-			return nil, errInvalidQuery
+			return nil, ErrInvalidQuery
 		}
 		tr := make(map[string]*Task)
 		for i := range ts {
@@ -158,6 +194,8 @@ func (c *cache) FindTasks(pattern string) (map[string]*Task, error) {
 }
 
 func (c *cache) GetTasksByIds(ids []string) map[string]*Task {
+	c.RLock()
+	defer c.RUnlock()
 	ts := make(map[string]*Task)
 	for i := range ids {
 		if _, ok := c.Tasks[ids[i]]; ok {
@@ -0,0 +1,135 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/zyxar/image2ascii/ascii"
+)
+
+// CaptchaSolver is consulted whenever the server asks for an image verification code.
+// Solve receives the raw verification image and returns the code shown in it.
+type CaptchaSolver interface {
+	Solve(ctx context.Context, image []byte) (string, error)
+}
+
+// CaptchaSolverFunc adapts an ordinary function to a CaptchaSolver.
+type CaptchaSolverFunc func(ctx context.Context, image []byte) (string, error)
+
+func (f CaptchaSolverFunc) Solve(ctx context.Context, image []byte) (string, error) {
+	return f(ctx, image)
+}
+
+type terminalSolver struct {
+	mutex sync.Mutex
+	in    *bufio.Reader
+	out   io.Writer
+}
+
+// NewTerminalSolver renders the image as colored ASCII art to out,
+// and reads the code as a line from in.
+func NewTerminalSolver(in io.Reader, out io.Writer) CaptchaSolver {
+	return &terminalSolver{in: bufio.NewReader(in), out: out}
+}
+
+func (t *terminalSolver) Solve(ctx context.Context, image []byte) (string, error) {
+	w, err := ascii.Decode(bytes.NewReader(image), ascii.Options{
+		Invert: true,
+		Color:  true,
+	})
+	if err != nil {
+		return "", err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	io.WriteString(t.out, "\n")
+	w.WriteTo(t.out)
+	io.WriteString(t.out, "input verify_code: ")
+	return t.in.ReadString('\n')
+}
+
+type fileSolver struct {
+	mutex sync.Mutex
+	path  string
+	in    *bufio.Reader
+	out   io.Writer
+}
+
+// NewFileSolver saves the image to path, tells out where it is,
+// and reads the code as a line from in.
+func NewFileSolver(path string, in io.Reader, out io.Writer) CaptchaSolver {
+	return &fileSolver{path: path, in: bufio.NewReader(in), out: out}
+}
+
+func (f *fileSolver) Solve(ctx context.Context, image []byte) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := ioutil.WriteFile(f.path, image, 0644); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(f.out, "verify image saved to %s\ninput verify_code: ", f.path)
+	return f.in.ReadString('\n')
+}
+
+type chanSolver struct {
+	images chan<- []byte
+	codes  <-chan string
+}
+
+// NewChanSolver sends every image on images and waits for the code on codes,
+// so that a daemon or a GUI can solve it elsewhere.
+func NewChanSolver(images chan<- []byte, codes <-chan string) CaptchaSolver {
+	return &chanSolver{images: images, codes: codes}
+}
+
+func (c *chanSolver) Solve(ctx context.Context, image []byte) (string, error) {
+	select {
+	case c.images <- image:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	select {
+	case code, ok := <-c.codes:
+		if !ok {
+			return "", ErrImageVerification
+		}
+		return code, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (s *session) SetCaptchaSolver(solver CaptchaSolver) {
+	s.lock()
+	s.solver = solver
+	s.unlock()
+}
+
+// solveCaptcha fetches a verification image and has it solved by s.solver.
+func (s *session) solveCaptcha(ctx context.Context) (string, error) {
+	s.lock()
+	solver := s.solver
+	s.unlock()
+	if solver == nil {
+		return "", ErrCaptchaRequired
+	}
+	image, err := s.getVerifyImage(ctx)
+	if err != nil {
+		return "", err
+	}
+	code, err := solver.Solve(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	code = strings.TrimSpace(code)
+	if len(code) == 0 {
+		return "", ErrImageVerification
+	}
+	return code, nil
+}
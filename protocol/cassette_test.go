@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zyxar/xunlei/protocol/xltest"
+)
+
+func TestCassette(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xunlei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cassette := filepath.Join(dir, "cassette.jsonl")
+	srv := xltest.NewServer()
+	srv.AddTask(xltest.Task{Name: "a.mkv", URL: "http://example.com/a.mkv", Size: 1 << 20, Type: xltest.TypeOrdinary})
+	recorder, err := NewRecorder(cassette, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{
+		LoginURL:      srv.URL,
+		LixianURL:     srv.URL,
+		VerifyURLs:    []string{srv.VerifyURL()},
+		CookieDomains: []string{srv.URL},
+		Transport:     recorder,
+	}
+	s, err := newSessionWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Login(xltest.Account, EncryptPass(xltest.Password)); err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := s.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.Close()
+	srv.Close()
+
+	b, err := ioutil.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{xltest.GdriveId, hashPass(EncryptPass(xltest.Password), "!XLT"), "sessionid=" + s.getCookie("sessionid")} {
+		if bytes.Contains(b, []byte(secret)) {
+			t.Errorf("expected %q to be redacted", secret)
+		}
+	}
+
+	if opts.Transport, err = NewReplayer(cassette); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = newSessionWithOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Login(xltest.Account, EncryptPass(xltest.Password)); err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := s.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != len(recorded) || replayed[0].TaskName != recorded[0].TaskName {
+		t.Errorf("unexpected replayed tasks: %v", replayed)
+	}
+	if _, err = s.GetDeletedTasks(); err == nil {
+		t.Error("expected unrecorded request to fail")
+	}
+}
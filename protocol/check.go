@@ -0,0 +1,156 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/zyxar/taipei"
+	"github.com/zyxar/xunlei/protocol/jsonp"
+)
+
+// URLCheck is what the server resolves a url to before it is added as a task.
+type URLCheck struct {
+	URL        string
+	Name       string
+	Size       int64 // in bytes
+	Cid        string
+	GCid       string
+	Exists     bool // a task of the url is already in the account
+	Goldbean   int  // cost of adding the task
+	Silverbean int
+	Err        error // the url could not be checked
+}
+
+// NeedsBean reports whether adding the task costs beans.
+func (c *URLCheck) NeedsBean() bool {
+	return c.Goldbean > 0 || c.Silverbean > 0
+}
+
+// batchCheckReply is the reply of batch_task_check:
+//
+//	<script>document.domain="xunlei.com";parent.begin_task_batch_resp({"rtcode":0,"data":[{"id":0,"url":"http://...","cid":"...","gcid":"...","filesize":"1048576","filename":"a.iso","goldbean":"0","silverbean":"0","exist":"0"}]})</script>
+type batchCheckReply struct {
+	*ErrorMessage
+	Data []struct {
+		Id         int    `json:"id"`
+		URL        string `json:"url"`
+		Cid        string `json:"cid"`
+		GCid       string `json:"gcid"`
+		FileSize   string `json:"filesize"`
+		FileName   string `json:"filename"`
+		Goldbean   string `json:"goldbean"`
+		Silverbean string `json:"silverbean"`
+		Exist      string `json:"exist"`
+	} `json:"data"`
+}
+
+// CheckURLsContext resolves urls without adding them, in one batch_task_check
+// request for ordinary and ed2k links; bt links and magnet links are queried
+// one by one, as for PreviewBtTask, except local torrent files, which are
+// not uploaded. A url that cannot be resolved has Err set, while err is
+// returned if the batch cannot be checked at all.
+func (s *session) CheckURLsContext(ctx context.Context, urls []string) ([]URLCheck, error) {
+	cs := make([]URLCheck, len(urls))
+	var batch []int
+	for i, req := range urls {
+		cs[i].URL = req
+		switch ttype, req := linkType(req); ttype {
+		case linkOrdinary, linkEd2k:
+			batch = append(batch, i)
+		default:
+			s.checkBtURL(ctx, &cs[i], ttype, req)
+		}
+	}
+	if len(batch) == 0 {
+		return cs, nil
+	}
+	v := url.Values{}
+	v.Add("callback", "begin_task_batch_resp")
+	v.Add("random", currentRandom())
+	links := make([]string, len(batch))
+	for k, i := range batch {
+		links[k] = urls[i]
+	}
+	v.Add("url", strings.Join(links, "\r\n"))
+	r, err := s.post(ctx, s.lixianURL(batchtaskcheckURI), v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	c, err := jsonp.Find(r, "begin_task_batch_resp")
+	if err != nil {
+		return nil, malformedResponse("batch_task_check", r, err)
+	}
+	var reply batchCheckReply
+	if err = c.Decode(0, &reply); err != nil {
+		return nil, malformedResponse("batch_task_check", r, err)
+	}
+	if code := reply.Rtcode(); code != "0" {
+		return nil, newAPIError("batch_task_check", code, reply.Msg(), r, ErrUnexpected)
+	}
+	resolved := make(map[int]bool)
+	for _, d := range reply.Data {
+		if d.Id < 0 || d.Id >= len(batch) || d.Cid == "" {
+			continue
+		}
+		resolved[d.Id] = true
+		uc := &cs[batch[d.Id]]
+		uc.Name = unescapeName(d.FileName)
+		uc.Size, _ = strconv.ParseInt(d.FileSize, 10, 64)
+		uc.Cid, uc.GCid = d.Cid, d.GCid
+		uc.Goldbean, _ = strconv.Atoi(d.Goldbean)
+		uc.Silverbean, _ = strconv.Atoi(d.Silverbean)
+		uc.Exists = d.Exist == "1" || s.hasTask(d.Cid)
+	}
+	for k, i := range batch {
+		if !resolved[k] {
+			cs[i].Err = &APIError{Endpoint: "batch_task_check", Message: "url not resolved", Body: r, Err: ErrInvalidQuery}
+		}
+	}
+	return cs, nil
+}
+
+// checkBtURL fills c with the torrent of the bt or magnet link req. A local
+// torrent file is only decoded, leaving its size and whether a task of it
+// exists unknown, as the server only reports them once it is uploaded.
+func (s *session) checkBtURL(ctx context.Context, c *URLCheck, ttype int, req string) {
+	if ttype == linkBt && !strings.HasPrefix(req, "bt://") {
+		b, err := ioutil.ReadFile(req)
+		if err != nil {
+			c.Err = err
+			return
+		}
+		m, err := taipei.DecodeMetaInfo(b)
+		if err != nil {
+			c.Err = fmt.Errorf("%w: %s: %v", ErrInvalidTorrent, req, err)
+			return
+		}
+		c.Name = m.Info.Name
+		return
+	}
+	p, err := s.previewBtTask(ctx, ttype, req)
+	if errors.Is(err, ErrTaskExisted) {
+		c.Exists = true
+		return
+	}
+	if err != nil {
+		c.Err = err
+		return
+	}
+	c.Name, c.Size, c.Cid = p.Name, p.Size, p.InfoId
+	c.Exists = s.hasTask(p.InfoId)
+}
+
+// hasTask reports whether a task of cid is cached and not deleted.
+func (s *session) hasTask(cid string) bool {
+	for _, t := range s.cache.snapshot() {
+		if strings.EqualFold(t.Cid, cid) && t.normal() {
+			return true
+		}
+	}
+	return false
+}
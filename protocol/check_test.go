@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zyxar/xunlei/protocol/xltest"
+)
+
+func TestCheckURLs(t *testing.T) {
+	s, srv := newTestSession(t)
+	defer srv.Close()
+	if err := s.AddTask("http://example.com/old.iso"); err != nil {
+		t.Fatal(err)
+	}
+	srv.SetCost("http://example.com/gold.iso", 2, 0)
+	hash := srv.AddTorrent("album", xltest.File{Name: "a.mkv", Size: 1 << 20}, xltest.File{Name: "b.mkv", Size: 1 << 10})
+	cs, err := s.CheckURLs([]string{
+		"http://example.com/old.iso",
+		"http://example.com/gold.iso",
+		"magnet:?xt=urn:btih:" + hash,
+		"missing.torrent",
+		"ftp://example.com/new.iso",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 5 {
+		t.Fatalf("expected 5 results, got %d", len(cs))
+	}
+	if c := cs[0]; c.Err != nil || !c.Exists || c.Name != "old.iso" || c.Size != 1<<20 || c.Cid == "" || c.GCid == "" || c.NeedsBean() {
+		t.Errorf("unexpected check: %+v", c)
+	}
+	if c := cs[1]; c.Err != nil || c.Exists || c.Goldbean != 2 || !c.NeedsBean() {
+		t.Errorf("unexpected check: %+v", c)
+	}
+	if c := cs[2]; c.Err != nil || c.Exists || c.Name != "album" || c.Cid != hash || c.Size != 1<<20+1<<10 {
+		t.Errorf("unexpected check: %+v", c)
+	}
+	if c := cs[3]; c.Err == nil {
+		t.Errorf("expected an error for a missing torrent, got %+v", c)
+	}
+	if c := cs[4]; c.Err != nil || c.Exists || c.Name != "new.iso" || c.URL != "ftp://example.com/new.iso" {
+		t.Errorf("unexpected check: %+v", c)
+	}
+	if n := srv.Requests("batch_task_check"); n != 1 {
+		t.Errorf("expected a single batch_task_check request, got %d", n)
+	}
+	dir, err := ioutil.TempDir("", "xunlei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	torrent := filepath.Join(dir, "album.torrent")
+	if err = ioutil.WriteFile(torrent, srv.TorrentFile(hash), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cs, err = s.CheckURLs([]string{torrent}); err != nil || cs[0].Err != nil {
+		t.Errorf("unexpected check of a local torrent: %+v, %v", cs, err)
+	}
+	if n := srv.Requests("torrent_upload"); n != 0 {
+		t.Errorf("expected local torrents not to be uploaded, got %d uploads", n)
+	}
+	if ts, _ := s.GetTasks(); len(ts) != 1 {
+		t.Errorf("expected checks not to add tasks, got %d tasks", len(ts))
+	}
+}
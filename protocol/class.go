@@ -0,0 +1,127 @@
+package protocol
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Category is a task category, or class as the server names it. Tasks in
+// no category are in category 0.
+type Category struct {
+	Id    int
+	Name  string
+	Count int // of the tasks in the category
+}
+
+// classReply is the reply of menu_get, menu_add and class_change:
+//
+//	jsonp1457357084473({"rtcode":0,"info":[{"id":"1","name":"movies","num":"2"}]})
+//	jsonp1457357084473({"rtcode":0,"id":"3"})
+type classReply struct {
+	*ErrorMessage
+	Id   string `json:"id"`
+	Info []struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+		Num  string `json:"num"`
+	} `json:"info"`
+}
+
+// classCall sends form, if any, to the class endpoint uri, and decodes its
+// reply, failing unless the rtcode is 0.
+func (s *session) classCall(ctx context.Context, endpoint, uri string, form url.Values) (*classReply, error) {
+	var r []byte
+	var err error
+	if form == nil {
+		r, err = s.get(ctx, uri)
+	} else {
+		r, err = s.post(ctx, uri, form.Encode())
+	}
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseCall(endpoint, r, "jsonp")
+	if err != nil {
+		return nil, err
+	}
+	var reply classReply
+	if err = c.Decode(0, &reply); err != nil {
+		return nil, malformedResponse(endpoint, r, err)
+	}
+	if code := reply.Rtcode(); code != "0" {
+		return nil, newAPIError(endpoint, code, reply.Msg(), r, ErrUnexpected)
+	}
+	return &reply, nil
+}
+
+// GetCategoriesContext lists the task categories of the account.
+func (s *session) GetCategoriesContext(ctx context.Context) ([]Category, error) {
+	reply, err := s.classCall(ctx, "menu_get", s.lixianURL(menugetURI, currentTimestamp()), nil)
+	if err != nil {
+		return nil, err
+	}
+	cs := make([]Category, len(reply.Info))
+	for i, info := range reply.Info {
+		cs[i].Id, _ = strconv.Atoi(info.Id)
+		cs[i].Name = unescapeName(info.Name)
+		cs[i].Count, _ = strconv.Atoi(info.Num)
+	}
+	return cs, nil
+}
+
+// AddCategoryContext creates the task category name.
+func (s *session) AddCategoryContext(ctx context.Context, name string) (*Category, error) {
+	form := url.Values{}
+	form.Add("name", name)
+	form.Add("uid", s.userId())
+	reply, err := s.classCall(ctx, "menu_add", s.lixianURL(menuaddURI, currentTimestamp()), form)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(reply.Id)
+	if err != nil {
+		return nil, malformedResponse("menu_add", []byte(reply.Id), err)
+	}
+	return &Category{Id: id, Name: name}, nil
+}
+
+// MoveTasksContext moves the tasks ids into the category classId, or out of
+// any category if classId is 0.
+func (s *session) MoveTasksContext(ctx context.Context, ids []string, classId int) error {
+	form := url.Values{}
+	form.Add("taskids", strings.Join(ids, ",")+",")
+	form.Add("class_id", strconv.Itoa(classId))
+	form.Add("uid", s.userId())
+	if _, err := s.classCall(ctx, "class_change", s.lixianURL(classchangeURI, currentTimestamp()), form); err != nil {
+		return err
+	}
+	for _, t := range s.cache.GetTasksByIds(ids) {
+		t.ClassValue = strconv.Itoa(classId)
+	}
+	return nil
+}
+
+// GetCategoryTasksContext lists the tasks in the category classId.
+func (s *session) GetCategoryTasksContext(ctx context.Context, classId int) ([]*Task, error) {
+	r, err := s.get(ctx, s.lixianURL(showclassURI, currentTimestamp(), classId))
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseCall("show_class", r, "jsonp")
+	if err != nil {
+		return nil, err
+	}
+	var resp taskResponse
+	if err = c.Decode(0, &resp); err != nil {
+		return nil, malformedResponse("show_class", r, err)
+	}
+	ts := make([]*Task, len(resp.Info.Tasks))
+	for i := range resp.Info.Tasks {
+		resp.Info.Tasks[i].TaskName = unescapeName(resp.Info.Tasks[i].TaskName)
+		ts[i] = &resp.Info.Tasks[i]
+	}
+	s.cache.pushTasks(ts)
+	return ts, nil
+}
@@ -0,0 +1,52 @@
+package protocol
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/zyxar/xunlei/protocol/xltest"
+)
+
+func TestCategories(t *testing.T) {
+	s, srv := newTestSession(t)
+	defer srv.Close()
+	movies := srv.AddClass("movies")
+	old := srv.AddTask(xltest.Task{Name: "old.mkv", URL: "http://example.com/old.mkv", Size: 1 << 30, Type: xltest.TypeOrdinary})
+	music, err := s.AddCategory("music")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.AddCategory(" "); !errors.Is(err, ErrUnexpected) {
+		t.Errorf("expected ErrUnexpected, got %v", err)
+	}
+	if err = s.AddTask("http://example.com/new.mkv", InCategory(movies)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.GetTasks(); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.MoveTasks([]string{old}, music.Id); err != nil {
+		t.Fatal(err)
+	}
+	if task, _ := srv.Task(old); task.Class != music.Id {
+		t.Errorf("expected task to be moved, got class %d", task.Class)
+	}
+	if ts, err := s.FindTasks("class=" + strconv.Itoa(music.Id)); err != nil || len(ts) != 1 || ts[old] == nil {
+		t.Errorf("unexpected tasks in class %d: %v, %v", music.Id, ts, err)
+	}
+	cs, err := s.GetCategories()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 2 || cs[0] != (Category{movies, "movies", 1}) || cs[1] != (Category{music.Id, "music", 1}) {
+		t.Errorf("unexpected categories: %+v", cs)
+	}
+	ts, err := s.GetCategoryTasks(movies)
+	if err != nil || len(ts) != 1 || ts[0].TaskName != "new.mkv" || ts[0].ClassValue != strconv.Itoa(movies) {
+		t.Errorf("unexpected tasks in class %d: %v, %v", movies, ts, err)
+	}
+	if err = s.MoveTasks([]string{old}, 42); !errors.Is(err, ErrUnexpected) {
+		t.Errorf("expected ErrUnexpected, got %v", err)
+	}
+}
@@ -1,28 +1,29 @@
 package protocol
 
+// kinds of the links given to AddTask; for ordinary and ed2k links, also
+// the type field of task_commit. Listed tasks report a TaskKind instead.
 const (
-	taskTypeOrdinary = iota
-	taskTypeBt
-	taskTypeEd2k
-	taskTypeInvalid
-	taskTypeMagnet
+	linkOrdinary = iota
+	linkBt
+	linkEd2k
+	linkInvalid
+	linkMagnet
 )
 
+// task list categories, see IterTasks.
 const (
-	statusWaiting = iota
-	statusDownloading
-	statusCompleted
-	statusFailed
-	statusMixed
-	statusPending
+	CategoryIncompleted = 1
+	CategoryCompleted   = 2
+	CategoryAll         = 4
 )
 
+// cache groups, by task flag.
 const (
-	flagNormal byte = iota
-	flagDeleted
-	flagPurged
-	flagInvalid
-	flagExpired
+	flagNormal  = byte(FlagNormal)
+	flagDeleted = byte(FlagDeleted)
+	flagPurged  = byte(FlagPurged)
+	flagInvalid = byte(3)
+	flagExpired = byte(FlagExpired)
 )
 
 const (
@@ -31,24 +32,30 @@ const (
 	btPageSize         = "999"
 	deletedCk          = `page_check_all=history&fltask_all_guoqi=1&class_check=0&page_check=task&fl_page_id=0&class_check_new=0&set_tab_status=11`
 	expiredCk          = `page_check_all=history&class_check=0&page_check=task&fl_page_id=0&class_check_new=0&set_tab_status=13`
+	domainLoginURI     = "http://login.xunlei.com/"
 	domainLixianURI    = "http://dynamic.cloud.vip.xunlei.com/"
-	taskBaseURI        = domainLixianURI + "user_task?userid=%s"
-	taskHomeURI        = domainLixianURI + "user_task?userid=%s&st=4"
-	taskPageURI        = domainLixianURI + "user_task?userid=%s&st=%s&p=%s"
-	historyHomeURI     = domainLixianURI + "user_history?userid=%s"
-	expireHomeURI      = domainLixianURI + "user_history?type=1&userid=%s"
-	historyPageURI     = domainLixianURI + "user_history?userid=%s&p=%d"
-	applyHomeURI       = domainLixianURI + "user_apply?userid=%s"
-	applyPageURI       = domainLixianURI + "user_apply?userid=%s&p=%s"
-	loginURI           = domainLixianURI + "login"
-	interfaceURI       = domainLixianURI + "interface"
+	logincheckURI      = "check?u=%s&cachetime=%d"
+	loginsubmitURI     = "sec2login/"
+	taskBaseURI        = "user_task?userid=%s"
+	taskHomeURI        = "user_task?userid=%s&st=4"
+	taskPageURI        = "user_task?userid=%s&st=%s&p=%s"
+	historyHomeURI     = "user_history?userid=%s"
+	expireHomeURI      = "user_history?type=1&userid=%s"
+	expirePageURI      = "user_history?type=1&userid=%s&p=%d"
+	historyPageURI     = "user_history?userid=%s&p=%d"
+	applyHomeURI       = "user_apply?userid=%s"
+	applyPageURI       = "user_apply?userid=%s&p=%s"
+	loginURI           = "login?cachetime=%d&from=0"
+	interfaceURI       = "interface"
 	verifyLoginURI     = interfaceURI + "/verify_login"
 	taskdelayURI       = interfaceURI + "/task_delay?taskids=%s&interfrom=%s&noCacheIE=%d"
 	gettorrentURI      = interfaceURI + "/get_torrent?userid=%s&infoid=%s"
 	taskpauseURI       = interfaceURI + "/task_pause?tid=%s&uid=%s&noCacheIE=%d"
 	redownloadURI      = interfaceURI + "/redownload?callback=jsonp%d"
 	showclassURI       = interfaceURI + "/show_class?callback=jsonp%d&type_id=%d"
-	menugetURI         = interfaceURI + "/menu_get"
+	menugetURI         = interfaceURI + "/menu_get?callback=jsonp%d"
+	menuaddURI         = interfaceURI + "/menu_add?callback=jsonp%d"
+	classchangeURI     = interfaceURI + "/class_change?callback=jsonp%d"
 	fillbtlistURI      = interfaceURI + "/fill_bt_list?callback=fill_bt_list&tid=%s&infoid=%s&g_net=1&p=%d&uid=%s&interfrom=%s&noCacheIE=%d"
 	taskcheckURI       = interfaceURI + "/task_check?callback=queryCid&url=%s&interfrom=%s&random=%s&tcache=%d"
 	taskcommitURI      = interfaceURI + "/task_commit?"
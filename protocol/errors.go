@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidSession       = errors.New("invalid session")
+	ErrInvalidResponse      = errors.New("invalid response")
+	ErrInvalidAccount       = errors.New("invalid login account")
+	ErrInvalidQuery         = errors.New("invalid query string")
+	ErrUnexpected           = errors.New("unexpected error")
+	ErrLoginFailed          = errors.New("login failed")
+	ErrTimeout              = errors.New("request time out")
+	ErrSessionExpired       = errors.New("previous session expired")
+	ErrTaskExisted          = errors.New("task already exists")
+	ErrImageVerification    = errors.New("image verification failed")
+	ErrCaptchaRequired      = errors.New("image verification code required")
+	ErrNoTasksInProgress    = errors.New("no tasks in progress")
+	ErrInvalidTaskFlag      = errors.New("invalid flag in task")
+	ErrTaskNoRedownCap      = errors.New("task not capable for restart")
+	ErrTaskNotFound         = errors.New("no such taskid in list")
+	ErrTaskNotCompleted     = errors.New("task not completed")
+	ErrTaskAlreadyQueued    = errors.New("task already in queue")
+	ErrTaskAlreadyPurged    = errors.New("task already purged")
+	ErrTaskAlreadyRemoved   = errors.New("task already deleted")
+	ErrTaskSubmissionFailed = errors.New("task submission failed")
+	ErrResourceReported     = errors.New("resource reported")
+	ErrNeedBean             = errors.New("task needs bean")
+	ErrNoFilesSelected      = errors.New("no files selected")
+	ErrInvalidTorrent       = errors.New("invalid torrent")
+)
+
+// rtcodes known to carry a specific meaning.
+var rtcodeErrors = map[string]error{
+	"75": ErrResourceReported,
+}
+
+// message fragments the server uses when no distinctive rtcode is given;
+// they are whole phrases, as single words such as 登录 (login) turn up in
+// unrelated messages.
+var messageErrors = []struct {
+	fragment string
+	err      error
+}{
+	{"举报", ErrResourceReported},
+	{"豆不足", ErrNeedBean},
+	{"需要消耗金豆", ErrNeedBean},
+	{"需要消耗银豆", ErrNeedBean},
+	{"已存在", ErrTaskExisted},
+	{"已经存在", ErrTaskExisted},
+	{"请重新登录", ErrSessionExpired},
+	{"登录超时", ErrSessionExpired},
+	{"登录已过期", ErrSessionExpired},
+}
+
+// APIError is returned when an endpoint replies with an error or with content
+// that cannot be understood. Err is the sentinel the reply was classified as,
+// if any, so both errors.Is and errors.As work on it:
+//
+//	if errors.Is(err, protocol.ErrResourceReported) { ... }
+//	var apiErr *protocol.APIError
+//	if errors.As(err, &apiErr) { log.Printf("%s: %s", apiErr.Code, apiErr.Body) }
+type APIError struct {
+	Endpoint string // e.g. "bt_task_commit"
+	Code     string // rtcode reported by the server, if any
+	Message  string // msg reported by the server, if any
+	Body     []byte // raw response
+	Err      error
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" && e.Err != nil {
+		msg = e.Err.Error()
+	}
+	if msg == "" {
+		msg = "error response"
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("%s: %s [%s]", e.Endpoint, msg, e.Code)
+	}
+	return fmt.Sprintf("%s: %s", e.Endpoint, msg)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// newAPIError classifies a server reply by its rtcode and message;
+// fallback is used when neither is recognised.
+func newAPIError(endpoint, code, msg string, body []byte, fallback error) *APIError {
+	e := &APIError{Endpoint: endpoint, Code: code, Message: msg, Body: body, Err: fallback}
+	if err, ok := rtcodeErrors[code]; ok {
+		e.Err = err
+		return e
+	}
+	for i := range messageErrors {
+		if strings.Contains(msg, messageErrors[i].fragment) {
+			e.Err = messageErrors[i].err
+			break
+		}
+	}
+	return e
+}
+
+func invalidResponse(endpoint string, body []byte) error {
+	return &APIError{Endpoint: endpoint, Body: body, Err: ErrInvalidResponse}
+}
+
+// malformedResponse reports why body could not be parsed.
+func malformedResponse(endpoint string, body []byte, err error) error {
+	return &APIError{Endpoint: endpoint, Message: err.Error(), Body: body, Err: ErrInvalidResponse}
+}
+
+func unexpectedResponse(endpoint string, body []byte) error {
+	return &APIError{Endpoint: endpoint, Body: body, Err: ErrUnexpected}
+}
+
+// Rtcode returns the rtcode as a string, whether the server sent it quoted or not.
+func (m *ErrorMessage) Rtcode() string {
+	if m == nil {
+		return ""
+	}
+	if s, err := strconv.Unquote(string(m.Code)); err == nil {
+		return s
+	}
+	return string(m.Code)
+}
+
+// Msg returns the msg of the reply, or "" if it has none.
+func (m *ErrorMessage) Msg() string {
+	if m == nil {
+		return ""
+	}
+	return m.Message
+}
@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestAPIErrorClassification(t *testing.T) {
+	body := []byte(`jsonp1457357084473({"id":"","avail_space":null,"progress":2,"time":0.47122287750244,"rtcode":"75","msg":"该资源被举报，无法添加到离线空间[0975]"})`)
+	var resp btSumbissionResponse
+	if err := json.Unmarshal(body[19:len(body)-1], &resp); err != nil {
+		t.Fatal(err)
+	}
+	err := resp.apiError(body)
+	if !errors.Is(err, ErrResourceReported) {
+		t.Errorf("expected ErrResourceReported, got %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *APIError, got %T", err)
+	}
+	if apiErr.Code != "75" || apiErr.Endpoint != "bt_task_commit" {
+		t.Errorf("unexpected APIError: %+v", apiErr)
+	}
+
+	err = newAPIError("bt_task_commit", "", "", nil, ErrTaskSubmissionFailed)
+	if !errors.Is(err, ErrTaskSubmissionFailed) {
+		t.Errorf("expected fallback error, got %v", err)
+	}
+
+	for msg, want := range map[string]error{
+		"登录超时，请重新登录":       ErrSessionExpired,
+		"金豆不足，无法添加":        ErrNeedBean,
+		"登录成功，但任务提交失败":     ErrTaskSubmissionFailed,
+		"豆瓣链接暂不支持":         ErrTaskSubmissionFailed,
+		"请登录迅雷会员后使用离线下载功能": ErrTaskSubmissionFailed,
+	} {
+		if err = newAPIError("task_commit", "", msg, nil, ErrTaskSubmissionFailed); !errors.Is(err, want) {
+			t.Errorf("expected %q to be classified as %v, got %v", msg, want, err)
+		}
+	}
+
+	var reply classReply
+	if err = json.Unmarshal([]byte(`{"info":[]}`), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if err = newAPIError("menu_get", reply.Rtcode(), reply.Msg(), nil, ErrUnexpected); !errors.Is(err, ErrUnexpected) {
+		t.Errorf("expected a reply without rtcode to be unexpected, got %v", err)
+	}
+}
+
+func TestGetTaskPreNeedBean(t *testing.T) {
+	_, err := getTaskPre([]byte(`queryCid('cid','gcid','1024','999','name','5','0','0','123','0')`))
+	if !errors.Is(err, ErrNeedBean) {
+		t.Errorf("expected ErrNeedBean, got %v", err)
+	}
+	_, err = getTaskPre([]byte(`garbage`))
+	if !errors.Is(err, ErrInvalidResponse) {
+		t.Errorf("expected ErrInvalidResponse, got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// historyFields maps the hidden inputs of a user_history row, whose ids are
+// the field name followed by the task id, to the task attributes they carry.
+var historyFields = map[string]func(t *Task, v string){
+	"d_status":   func(t *Task, v string) { t.DownloadStatus = v },
+	"dflag":      func(t *Task, v string) { t.Flag = v },
+	"dcid":       func(t *Task, v string) { t.Cid = v },
+	"f_url":      func(t *Task, v string) { t.URL = v },
+	"taskname":   func(t *Task, v string) { t.TaskName = unescapeName(v) },
+	"ysfilesize": func(t *Task, v string) { t.YsFileSize = v },
+	"dl_url":     func(t *Task, v string) { t.LixianURL = v },
+	"d_tasktype": func(t *Task, v string) {
+		n, _ := strconv.Atoi(v)
+		t.TaskType = byte(n)
+	},
+}
+
+// historyTexts maps the classes of the span and em elements of a
+// user_history row to the task attributes their text carries.
+var historyTexts = map[string]func(t *Task, v string){
+	"c_addtime": func(t *Task, v string) { t.DtCommitted = v },
+	"loadnum": func(t *Task, v string) {
+		p, _ := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 32)
+		t.Progress = float32(p)
+	},
+}
+
+// parseHistory parses a user_history page, listing deleted or expired tasks,
+// and reports whether a next page follows. Tasks without a dflag input get
+// flag. It fails if the page holds no task list, e.g. when the session has
+// expired and the server answers with a login page instead.
+func parseHistory(in []byte, flag string) ([]*Task, bool, error) {
+	var (
+		ts     = []*Task{}
+		task   *Task
+		text   func(t *Task, v string)
+		found  bool
+		inNext bool
+		more   bool
+		depth  int // of the open div elements, the row being at depth 1
+	)
+	z := html.NewTokenizer(bytes.NewReader(in))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return nil, false, malformedResponse("user_history", in, err)
+			}
+			if !found {
+				return nil, false, malformedResponse("user_history", in, errors.New("no task list"))
+			}
+			if task != nil {
+				return nil, false, malformedResponse("user_history", in, io.ErrUnexpectedEOF)
+			}
+			return ts, more, nil
+		case html.TextToken:
+			if text != nil && task != nil {
+				text(task, strings.TrimSpace(string(z.Text())))
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "div":
+				if task != nil {
+					if depth--; depth == 0 {
+						if task.Id == "" {
+							return nil, false, malformedResponse("user_history", in, errors.New("task without id"))
+						}
+						ts = append(ts, task)
+						task = nil
+					}
+				}
+			case "span", "em":
+				text = nil
+			case "li":
+				inNext = false
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			attrs := make(map[string]string)
+			for hasAttr {
+				var k, v []byte
+				k, v, hasAttr = z.TagAttr()
+				attrs[string(k)] = string(v)
+			}
+			switch string(name) {
+			case "div":
+				if attrs["id"] == "rowbox_list" {
+					found = true
+				} else if task == nil && hasClass(attrs["class"], "rw_list") {
+					task = &Task{Id: attrs["taskid"], Flag: flag}
+					depth = 0
+				}
+				if task != nil && tt == html.StartTagToken {
+					depth++
+				}
+			case "input":
+				if task != nil {
+					readHistoryInput(task, attrs["id"], attrs["value"])
+				}
+			case "span", "em":
+				text = nil
+				for _, class := range strings.Fields(attrs["class"]) {
+					if f, ok := historyTexts[class]; ok {
+						text = f
+					}
+				}
+			case "li":
+				inNext = hasClass(attrs["class"], "next")
+			case "a":
+				if inNext && attrs["href"] != "" {
+					more = true
+				}
+			}
+		}
+	}
+}
+
+// readHistoryInput sets the task attribute carried by the hidden input id,
+// named after the attribute and suffixed with the task id.
+func readHistoryInput(task *Task, id, value string) {
+	for field, set := range historyFields {
+		if !strings.HasPrefix(id, field) {
+			continue
+		}
+		taskId := id[len(field):]
+		if taskId == "" || strings.Trim(taskId, "0123456789") != "" {
+			continue
+		}
+		if task.Id == "" {
+			task.Id = taskId
+		} else if task.Id != taskId {
+			return
+		}
+		set(task, value)
+		return
+	}
+}
+
+func hasClass(class, name string) bool {
+	for _, c := range strings.Fields(class) {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+const historyPage = `<html><body>
+<div class="rwbox" id="rowbox_list">
+<div class="rw_list" id="tr_c1001" taskid="1001">
+  <div class="rw_inter">
+    <input id="d_status1001" type="hidden" value="2" />
+    <input id="dflag1001" type="hidden" value="2" />
+    <input id="dcid1001" type="hidden" value="0123456789ABCDEF" />
+    <input id="f_url1001" type="hidden" value="http://example.com/a?x=1&amp;y=2" />
+    <input id="taskname1001" type="hidden" value="a &amp;amp; b.mkv" />
+    <input id="d_tasktype1001" type="hidden" value="1" />
+    <input id="ysfilesize1001" type="hidden" value="1073741824" />
+    <input id="dl_url1001" type="hidden" value="http://gdl.lixian.vip.xunlei.com/download?fid=1" />
+  </div>
+  <span class="c_addtime">2014-05-01 12:00:00</span>
+  <em class="loadnum">100%</em>
+</div>
+<div class="rw_list" id="tr_c1002">
+  <input id="d_status1002" type="hidden" value="1" />
+  <input id="taskname1002" type="hidden" value="c" />
+  <input id="d_tasktype1002" type="hidden" value="0" />
+  <em class="loadnum">42.5%</em>
+</div>
+</div>
+<div class="page"><ul><li class="prev"><a href="#">上一页</a></li><li class="next"><a href="/user_history?p=2">下一页</a></li></ul></div>
+</body></html>`
+
+func TestParseHistory(t *testing.T) {
+	ts, more, err := parseHistory([]byte(historyPage), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !more {
+		t.Error("expected a next page")
+	}
+	if len(ts) != 2 {
+		t.Fatalf("expected 2 tasks, got %d", len(ts))
+	}
+	a := ts[0]
+	if a.Id != "1001" || a.DownloadStatus != "2" || a.Flag != "2" || a.Cid != "0123456789ABCDEF" ||
+		a.URL != "http://example.com/a?x=1&y=2" || a.TaskName != "a & b.mkv" || a.TaskType != 1 ||
+		a.YsFileSize != "1073741824" || a.LixianURL != "http://gdl.lixian.vip.xunlei.com/download?fid=1" ||
+		a.DtCommitted != "2014-05-01 12:00:00" || a.Progress != 100 {
+		t.Errorf("unexpected task: %+v", a)
+	}
+	b := ts[1]
+	if b.Id != "1002" || b.Flag != "1" || b.TaskName != "c" || b.TaskType != 0 || b.Progress != 42.5 {
+		t.Errorf("unexpected task: %+v", b)
+	}
+
+	ts, more, err = parseHistory([]byte(`<div id="rowbox_list"></div><ul><li class="next"></li></ul>`), "4")
+	if err != nil || more || len(ts) != 0 {
+		t.Errorf("expected an empty last page, got %v, %v, %v", ts, more, err)
+	}
+
+	for _, page := range []string{
+		`<script>top.location='http://cloud.vip.xunlei.com/task.html?error=1'</script>`,
+		`<div id="rowbox_list"><div class="rw_list"><input id="taskname" value="x" /></div></div>`,
+		`<div id="rowbox_list"><div class="rw_list" taskid="1"><input id="taskname1" value="x" />`,
+	} {
+		if _, _, err = parseHistory([]byte(page), "1"); !errors.Is(err, ErrInvalidResponse) {
+			t.Errorf("expected ErrInvalidResponse for %q, got %v", page, err)
+		}
+	}
+}
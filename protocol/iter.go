@@ -0,0 +1,128 @@
+package protocol
+
+import "context"
+
+// TaskIterator streams the tasks of a category, fetching pages of the list
+// only when the tasks already fetched have been consumed. Once the first page
+// tells how many follow, up to PageWorkers of them are fetched ahead, and each
+// is yielded as soon as it and those before it have arrived:
+//
+//	it := s.IterTasks(ctx, CategoryAll)
+//	for it.Next() {
+//		t := it.Task()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Iteration may be abandoned at any point.
+type TaskIterator struct {
+	s        *session
+	ctx      context.Context
+	category int
+	limit    int
+	pages    *pageFetcher
+	page     int
+	total    int
+	fetched  int
+	count    int
+	tasks    []*Task
+	task     *Task
+	err      error
+	done     bool
+}
+
+// IterTasks returns an iterator over the tasks of category, one of
+// CategoryAll, CategoryCompleted and CategoryIncompleted, yielding at most
+// limit tasks if given.
+func (s *session) IterTasks(ctx context.Context, category int, limit ...int) *TaskIterator {
+	it := &TaskIterator{s: s, ctx: ctx, category: category, total: -1}
+	if len(limit) > 0 && limit[0] > 0 {
+		it.limit = limit[0]
+	}
+	it.pages = newPageFetcher(ctx, s.pageWorkers, 1, func(ctx context.Context, page int) (r pageResult) {
+		r.tasks, r.total, r.err = s.taskPage(ctx, category, page)
+		return
+	})
+	return it
+}
+
+// Next advances to the next task, fetching the next page if needed;
+// it returns false when the tasks are exhausted or an error occurs.
+func (it *TaskIterator) Next() bool {
+	if it.limit > 0 && it.count >= it.limit {
+		it.tasks = nil
+		it.stop()
+	}
+	for !it.done && len(it.tasks) == 0 {
+		it.fetch()
+	}
+	if len(it.tasks) == 0 {
+		it.task = nil
+		return false
+	}
+	it.task, it.tasks = it.tasks[0], it.tasks[1:]
+	it.count++
+	return true
+}
+
+// NextPage returns the remaining tasks of the current page, or those of
+// the next page if it has been consumed; it returns nil when the tasks are
+// exhausted or an error occurs.
+func (it *TaskIterator) NextPage() []*Task {
+	if !it.Next() {
+		return nil
+	}
+	ts := append([]*Task{it.task}, it.tasks...)
+	if it.limit > 0 && it.count+len(it.tasks) > it.limit {
+		ts = ts[:it.limit-it.count+1]
+	}
+	it.count += len(ts) - 1
+	it.tasks = nil
+	it.task = ts[len(ts)-1]
+	return ts
+}
+
+// Task returns the current task.
+func (it *TaskIterator) Task() *Task { return it.task }
+
+// Err returns the error that stopped the iteration, if any.
+func (it *TaskIterator) Err() error { return it.err }
+
+// Total returns the number of tasks in the category as reported by the
+// server, or -1 before the first page is fetched.
+func (it *TaskIterator) Total() int { return it.total }
+
+// fetch takes the next page, and lets the pages left be fetched ahead once
+// the first page tells how many there are. The fetches ahead are cancelled at
+// the end of the list or on an error.
+func (it *TaskIterator) fetch() {
+	p, ok := it.pages.page()
+	if !ok {
+		it.stop()
+		return
+	}
+	if p.err != nil {
+		it.err = p.err
+		it.stop()
+		return
+	}
+	it.page++
+	it.tasks, it.total = p.tasks, p.total
+	it.fetched += len(p.tasks)
+	want := it.total
+	if it.limit > 0 && it.limit < want {
+		want = it.limit
+	}
+	if len(p.tasks) == 0 || it.fetched >= want {
+		it.stop()
+		return
+	}
+	it.pages.last = it.page + (want-it.fetched+tasksPerPage-1)/tasksPerPage
+}
+
+func (it *TaskIterator) stop() {
+	it.done = true
+	it.pages.stop()
+}
@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zyxar/xunlei/protocol/xltest"
+)
+
+func TestIterTasks(t *testing.T) {
+	s, srv := newTestSession(t)
+	defer srv.Close()
+	for i := 0; i < 250; i++ {
+		status := xltest.StatusDownloading
+		if i%5 == 0 {
+			status = xltest.StatusCompleted
+		}
+		srv.AddTask(xltest.Task{Name: strconv.Itoa(i), URL: "http://example.com/" + strconv.Itoa(i), Type: xltest.TypeOrdinary, Status: status})
+	}
+	srv.SetLatency(10 * time.Millisecond)
+	ctx := context.Background()
+	it := s.IterTasks(ctx, CategoryAll)
+	n := 0
+	for it.Next() {
+		if name := strconv.Itoa(249 - n); it.Task().TaskName != name {
+			t.Fatalf("expected task %s, got %s", name, it.Task().TaskName)
+		}
+		n++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 250 || it.Total() != 250 {
+		t.Errorf("expected 250 tasks, got %d of %d", n, it.Total())
+	}
+	if requests := srv.Requests("showtask_unfresh"); requests != 3 {
+		t.Errorf("expected 3 pages to be fetched, got %d", requests)
+	}
+	if peak := srv.Peak("showtask_unfresh"); peak != 2 {
+		t.Errorf("expected the last 2 pages to be fetched concurrently, got %d", peak)
+	}
+
+	it = s.IterTasks(ctx, CategoryAll)
+	for i := 0; i < 10 && it.Next(); i++ {
+	}
+	if requests := srv.Requests("showtask_unfresh"); requests != 4 {
+		t.Errorf("expected a single page to be fetched, got %d", requests-3)
+	}
+
+	ts, err := s.GetTasks(120)
+	if err != nil || len(ts) != 120 {
+		t.Errorf("expected 120 tasks, got %d, %v", len(ts), err)
+	}
+	if ts, err = s.GetCompletedTasks(); err != nil || len(ts) != 50 {
+		t.Errorf("expected 50 completed tasks, got %d, %v", len(ts), err)
+	}
+	if ts, err = s.GetIncompletedTasks(); err != nil || len(ts) != 200 {
+		t.Errorf("expected 200 incompleted tasks, got %d, %v", len(ts), err)
+	}
+}
@@ -0,0 +1,474 @@
+// Package jsonp parses the JavaScript the lixian web service answers with:
+// JSONP callbacks such as `rebuild({...})`, and calls whose arguments are
+// JavaScript literals, e.g.
+//
+//	queryUrl(1,'HASH','1024','name','0',new Array('a','b'),...)
+//
+// Arguments are decoded into string, json.Number, bool, nil, []interface{}
+// and map[string]interface{} values; single-quoted strings, unquoted object
+// keys and `new Array(...)` are understood.
+package jsonp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// SyntaxError is returned when the input is not a call of JavaScript literals.
+type SyntaxError struct {
+	Offset int // where the error occurred
+	msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("jsonp: %s at offset %d", e.msg, e.Offset)
+}
+
+// Call is a parsed function call.
+type Call struct {
+	Name string
+	Args []interface{}
+	raw  [][]byte
+}
+
+// Parse parses b, which must consist of a single call such as `name(args)`,
+// optionally followed by a semicolon. A call without a name, `(args)`,
+// has an empty Name.
+func Parse(b []byte) (*Call, error) {
+	p := &parser{b: b}
+	p.skip()
+	name := p.ident()
+	c, err := p.call(name)
+	if err != nil {
+		return nil, err
+	}
+	p.skip()
+	if p.peek() == ';' {
+		p.i++
+		p.skip()
+	}
+	if p.i < len(b) {
+		return nil, p.errorf("unexpected %q after call", p.b[p.i])
+	}
+	return c, nil
+}
+
+// Find parses the first call of name within b, ignoring what surrounds it,
+// e.g. the HTML of a <script> element; name may be a method of an object,
+// as in `parent.name(...)`.
+func Find(b []byte, name string) (*Call, error) {
+	for from := 0; ; {
+		i := bytes.Index(b[from:], []byte(name+"("))
+		if i < 0 {
+			return nil, fmt.Errorf("jsonp: no call of %s", name)
+		}
+		i += from
+		if i == 0 || b[i-1] == '.' || !isIdent(b[i-1]) {
+			p := &parser{b: b, i: i + len(name)}
+			return p.call(name)
+		}
+		from = i + 1
+	}
+}
+
+// ParseValue parses the literal at the start of b, and returns the rest of b.
+func ParseValue(b []byte) (interface{}, []byte, error) {
+	p := &parser{b: b}
+	v, err := p.value()
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, b[p.i:], nil
+}
+
+// Unmarshal stores the parsed value v in the value pointed to by dst,
+// following the rules of encoding/json.
+func Unmarshal(v, dst interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+func (c *Call) arg(i int) (interface{}, error) {
+	if i >= len(c.Args) {
+		return nil, fmt.Errorf("jsonp: %s has %d arguments, want at least %d", c.Name, len(c.Args), i+1)
+	}
+	return c.Args[i], nil
+}
+
+// Raw returns the source text of argument i, or nil if there is no such
+// argument.
+func (c *Call) Raw(i int) []byte {
+	if i >= len(c.raw) {
+		return nil
+	}
+	return c.raw[i]
+}
+
+// String returns argument i, a string or a number, as a string.
+func (c *Call) String(i int) (string, error) {
+	v, err := c.arg(i)
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return string(v), nil
+	}
+	return "", fmt.Errorf("jsonp: argument %d of %s is %s, not a string", i, c.Name, kind(v))
+}
+
+// Int returns argument i, a number or a numeric string, as an int.
+func (c *Call) Int(i int) (int, error) {
+	s, err := c.String(i)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("jsonp: argument %d of %s is %q, not an integer", i, c.Name, s)
+	}
+	return n, nil
+}
+
+// Strings returns argument i, an array of strings or numbers, as strings.
+func (c *Call) Strings(i int) ([]string, error) {
+	v, err := c.arg(i)
+	if err != nil {
+		return nil, err
+	}
+	a, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("jsonp: argument %d of %s is %s, not an array", i, c.Name, kind(v))
+	}
+	ss := make([]string, len(a))
+	for j := range a {
+		switch e := a[j].(type) {
+		case string:
+			ss[j] = e
+		case json.Number:
+			ss[j] = string(e)
+		default:
+			return nil, fmt.Errorf("jsonp: element %d of argument %d of %s is %s, not a string", j, i, c.Name, kind(e))
+		}
+	}
+	return ss, nil
+}
+
+// Decode stores argument i in the value pointed to by dst, see Unmarshal.
+func (c *Call) Decode(i int, dst interface{}) error {
+	v, err := c.arg(i)
+	if err != nil {
+		return err
+	}
+	if err = Unmarshal(v, dst); err != nil {
+		return fmt.Errorf("jsonp: argument %d of %s: %w", i, c.Name, err)
+	}
+	return nil
+}
+
+func kind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+type parser struct {
+	b []byte
+	i int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Offset: p.i, msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) peek() byte {
+	if p.i < len(p.b) {
+		return p.b[p.i]
+	}
+	return 0
+}
+
+func (p *parser) skip() {
+	for p.i < len(p.b) {
+		switch p.b[p.i] {
+		case ' ', '\t', '\r', '\n':
+			p.i++
+		default:
+			return
+		}
+	}
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '$' || c == '.' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func (p *parser) ident() string {
+	start := p.i
+	for p.i < len(p.b) && isIdent(p.b[p.i]) {
+		p.i++
+	}
+	return string(p.b[start:p.i])
+}
+
+// call parses the parenthesized arguments following name.
+func (p *parser) call(name string) (*Call, error) {
+	p.skip()
+	if p.peek() != '(' {
+		return nil, p.errorf("expected ( after %q", name)
+	}
+	p.i++
+	c := &Call{Name: name}
+	for {
+		p.skip()
+		if p.peek() == ')' && len(c.Args) == 0 {
+			p.i++
+			return c, nil
+		}
+		start := p.i
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		c.Args = append(c.Args, v)
+		c.raw = append(c.raw, p.b[start:p.i])
+		p.skip()
+		switch p.peek() {
+		case ',':
+			p.i++
+		case ')':
+			p.i++
+			return c, nil
+		case 0:
+			return nil, p.errorf("unterminated call of %q", name)
+		default:
+			return nil, p.errorf("unexpected %q in arguments of %q", p.peek(), name)
+		}
+	}
+}
+
+func (p *parser) value() (interface{}, error) {
+	p.skip()
+	switch c := p.peek(); {
+	case c == 0:
+		return nil, p.errorf("unexpected end of input")
+	case c == '\'' || c == '"':
+		return p.str()
+	case c == '{':
+		return p.object()
+	case c == '[':
+		p.i++
+		return p.elements(']')
+	case c == '-' || c == '+' || c == '.' || '0' <= c && c <= '9':
+		return p.number()
+	case isIdent(c):
+		start := p.i
+		switch id := p.ident(); id {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null", "undefined":
+			return nil, nil
+		case "new":
+			p.skip()
+			if id = p.ident(); id != "Array" {
+				return nil, p.errorf("unsupported constructor %q", id)
+			}
+			p.skip()
+			if p.peek() != '(' {
+				return nil, p.errorf("expected ( after new Array")
+			}
+			p.i++
+			return p.elements(')')
+		default:
+			p.i = start
+			return nil, p.errorf("unexpected identifier %q", id)
+		}
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+// elements parses the elements of an array up to end.
+func (p *parser) elements(end byte) ([]interface{}, error) {
+	a := []interface{}{}
+	for {
+		p.skip()
+		if p.peek() == end {
+			p.i++
+			return a, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+		p.skip()
+		switch p.peek() {
+		case ',':
+			p.i++
+		case end:
+		default:
+			return nil, p.errorf("expected , or %q in array", end)
+		}
+	}
+}
+
+func (p *parser) object() (map[string]interface{}, error) {
+	p.i++
+	m := make(map[string]interface{})
+	for {
+		p.skip()
+		var key string
+		switch c := p.peek(); {
+		case c == '}':
+			p.i++
+			return m, nil
+		case c == '\'' || c == '"':
+			var err error
+			if key, err = p.str(); err != nil {
+				return nil, err
+			}
+		case isIdent(c):
+			key = p.ident()
+		default:
+			return nil, p.errorf("expected object key")
+		}
+		p.skip()
+		if p.peek() != ':' {
+			return nil, p.errorf("expected : after object key %q", key)
+		}
+		p.i++
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+		p.skip()
+		switch p.peek() {
+		case ',':
+			p.i++
+		case '}':
+		default:
+			return nil, p.errorf("expected , or } in object")
+		}
+	}
+}
+
+func (p *parser) number() (json.Number, error) {
+	start := p.i
+	if c := p.peek(); c == '-' || c == '+' {
+		p.i++
+	}
+	for p.i < len(p.b) {
+		c := p.b[p.i]
+		if '0' <= c && c <= '9' || c == '.' || c == 'e' || c == 'E' || (c == '-' || c == '+') && (p.b[p.i-1] == 'e' || p.b[p.i-1] == 'E') {
+			p.i++
+			continue
+		}
+		break
+	}
+	s := string(p.b[start:p.i])
+	if s[0] == '+' {
+		s = s[1:]
+	}
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		p.i = start
+		return "", p.errorf("invalid number %q", s)
+	}
+	if s[0] == '.' {
+		s = "0" + s
+	} else if len(s) > 1 && s[0] == '-' && s[1] == '.' {
+		s = "-0" + s[1:]
+	}
+	return json.Number(s), nil
+}
+
+func (p *parser) str() (string, error) {
+	start := p.i
+	quote := p.b[p.i]
+	p.i++
+	var buf []byte
+	for p.i < len(p.b) {
+		c := p.b[p.i]
+		switch {
+		case c == quote:
+			p.i++
+			return string(buf), nil
+		case c == '\\':
+			p.i++
+			if p.i >= len(p.b) {
+				break
+			}
+			e := p.b[p.i]
+			p.i++
+			switch e {
+			case 'n':
+				buf = append(buf, '\n')
+			case 't':
+				buf = append(buf, '\t')
+			case 'r':
+				buf = append(buf, '\r')
+			case 'b':
+				buf = append(buf, '\b')
+			case 'f':
+				buf = append(buf, '\f')
+			case 'u', 'x':
+				n := 4
+				if e == 'x' {
+					n = 2
+				}
+				if p.i+n > len(p.b) {
+					return "", p.errorf("invalid escape \\%c", e)
+				}
+				r, err := strconv.ParseUint(string(p.b[p.i:p.i+n]), 16, 32)
+				if err != nil {
+					return "", p.errorf("invalid escape \\%c%s", e, p.b[p.i:p.i+n])
+				}
+				p.i += n
+				if utf16.IsSurrogate(rune(r)) && p.i+6 <= len(p.b) && p.b[p.i] == '\\' && p.b[p.i+1] == 'u' {
+					if r2, err := strconv.ParseUint(string(p.b[p.i+2:p.i+6]), 16, 32); err == nil {
+						if d := utf16.DecodeRune(rune(r), rune(r2)); d != utf8.RuneError {
+							r = uint64(d)
+							p.i += 6
+						}
+					}
+				}
+				buf = append(buf, string(rune(r))...)
+			case '\n':
+			default:
+				buf = append(buf, e)
+			}
+		case c == '\n':
+			return "", p.errorf("newline in string")
+		default:
+			_, size := utf8.DecodeRune(p.b[p.i:])
+			buf = append(buf, p.b[p.i:p.i+size]...)
+			p.i += size
+		}
+	}
+	p.i = start
+	return "", p.errorf("unterminated string")
+}
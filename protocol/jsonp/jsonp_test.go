@@ -0,0 +1,95 @@
+package jsonp
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`queryUrl(1,'ABC','1024','it\'s "quoted"','0',new Array('a.mkv','b'),new Array(),-1.5,true,null,{id:"1",'n':[2]}) ;`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Call{
+		Name: "queryUrl",
+		Args: []interface{}{
+			json.Number("1"), "ABC", "1024", `it's "quoted"`, "0",
+			[]interface{}{"a.mkv", "b"}, []interface{}{}, json.Number("-1.5"), true, nil,
+			map[string]interface{}{"id": "1", "n": []interface{}{json.Number("2")}},
+		},
+	}
+	if !reflect.DeepEqual(c.Args, expected.Args) || c.Name != expected.Name {
+		t.Errorf("unexpected call: %#v", c)
+	}
+	if n, err := c.Int(0); err != nil || n != 1 {
+		t.Errorf("unexpected Int(0): %d, %v", n, err)
+	}
+	if s, err := c.String(2); err != nil || s != "1024" {
+		t.Errorf("unexpected String(2): %q, %v", s, err)
+	}
+	if ss, err := c.Strings(5); err != nil || !reflect.DeepEqual(ss, []string{"a.mkv", "b"}) {
+		t.Errorf("unexpected Strings(5): %q, %v", ss, err)
+	}
+	if _, err = c.String(5); err == nil {
+		t.Error("expected an array not to be a string")
+	}
+	if _, err = c.String(11); err == nil {
+		t.Error("expected a missing argument to fail")
+	}
+	var v struct {
+		Id string `json:"id"`
+		N  []int  `json:"n"`
+	}
+	if err = c.Decode(10, &v); err != nil || v.Id != "1" || len(v.N) != 1 || v.N[0] != 2 {
+		t.Errorf("unexpected Decode(10): %+v, %v", v, err)
+	}
+	if string(c.Raw(5)) != "new Array('a.mkv','b')" {
+		t.Errorf("unexpected Raw(5): %s", c.Raw(5))
+	}
+
+	if c, err = Parse([]byte(`({"result":0,"name":"中😀"})`)); err != nil || c.Name != "" {
+		t.Fatalf("unexpected call: %v, %v", c, err)
+	}
+	if m := c.Args[0].(map[string]interface{}); m["name"] != "中😀" {
+		t.Errorf("unexpected unescaping: %q", m["name"])
+	}
+	if c, err = Parse([]byte("pause_task_resp()")); err != nil || len(c.Args) != 0 {
+		t.Errorf("unexpected call: %v, %v", c, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, in := range []string{
+		``,
+		`rebuild(`,
+		`rebuild({"a":1)`,
+		`rebuild('unterminated)`,
+		`rebuild(1) trailing`,
+		`rebuild(foo)`,
+		`<script>rebuild(1)</script>`,
+	} {
+		if _, err := Parse([]byte(in)); err == nil {
+			t.Errorf("expected %q to fail", in)
+		} else if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("expected a SyntaxError for %q, got %v", in, err)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	c, err := Find([]byte(`<script>document.domain="xunlei.com";parent.edit_bt_list({"infoid":"ABC"},'');</script>`), "edit_bt_list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Args) != 2 {
+		t.Errorf("unexpected arguments: %v", c.Args)
+	}
+	if _, err = Find([]byte(`x_alert('no')`), "alert"); err == nil {
+		t.Error("expected a call of another function not to match")
+	}
+	v, rest, err := ParseValue([]byte(`{"ret_value":1};var btRtcode = 0`))
+	if err != nil || string(rest) != ";var btRtcode = 0" {
+		t.Errorf("unexpected ParseValue: %v, %q, %v", v, rest, err)
+	}
+}
@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"context"
+	"os"
+	"sort"
+	"sync"
+)
+
+// SessionManager holds named sessions, one per account, each with its own
+// cookies, task cache and SessionStore.
+type SessionManager struct {
+	opts     *Options
+	newStore func(name string) SessionStore
+	mutex    sync.Mutex
+	sessions map[string]Session
+}
+
+// NewSessionManager creates sessions configured by opts, and saves each one
+// to the store returned by newStore for its name.
+func NewSessionManager(opts *Options, newStore func(name string) SessionStore) *SessionManager {
+	return &SessionManager{
+		opts:     opts,
+		newStore: newStore,
+		sessions: make(map[string]Session),
+	}
+}
+
+// Get returns the session named name, if it has been opened.
+func (m *SessionManager) Get(name string) (Session, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, ok := m.sessions[name]
+	return s, ok
+}
+
+// Names returns the names of the opened sessions in order.
+func (m *SessionManager) Names() []string {
+	m.mutex.Lock()
+	names := make([]string, 0, len(m.sessions))
+	for name := range m.sessions {
+		names = append(names, name)
+	}
+	m.mutex.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// Store returns the store of the session named name.
+func (m *SessionManager) Store(name string) SessionStore {
+	return m.newStore(name)
+}
+
+// Open returns the session named name, creating it and resuming it from its
+// store if needed. The session is returned even when it cannot be resumed,
+// so that it can log in; a store that does not exist yet is not an error.
+func (m *SessionManager) Open(ctx context.Context, name string) (Session, error) {
+	m.mutex.Lock()
+	s, ok := m.sessions[name]
+	if ok {
+		m.mutex.Unlock()
+		return s, nil
+	}
+	s, err := NewSessionWithOptions(m.opts)
+	if err != nil {
+		m.mutex.Unlock()
+		return nil, err
+	}
+	m.sessions[name] = s
+	m.mutex.Unlock()
+	if err = s.ResumeSessionFromContext(ctx, m.newStore(name)); os.IsNotExist(err) {
+		err = nil
+	}
+	return s, err
+}
+
+// Login logs the session named name into the account id, opening the session
+// if needed, and saves it to its store.
+func (m *SessionManager) Login(ctx context.Context, name, id, passhash string) (*LoginResult, error) {
+	s, err := m.Open(ctx, name)
+	if s == nil {
+		return nil, err
+	}
+	result, err := s.LoginContext(ctx, id, passhash)
+	if err != nil {
+		return nil, err
+	}
+	return result, s.SaveSessionTo(m.newStore(name))
+}
+
+// Remove forgets the session named name; its store is left untouched.
+func (m *SessionManager) Remove(name string) {
+	m.mutex.Lock()
+	delete(m.sessions, name)
+	m.mutex.Unlock()
+}
@@ -0,0 +1,42 @@
+package protocol
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSessionManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xunlei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m := NewSessionManager(nil, func(name string) SessionStore {
+		return NewFileStore(filepath.Join(dir, name+".json"))
+	})
+	b, err := m.Open(context.Background(), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := m.Open(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("expected distinct sessions")
+	}
+	if s, _ := m.Open(context.Background(), "a"); s != a {
+		t.Error("expected the opened session to be reused")
+	}
+	if names := m.Names(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("unexpected names: %v", names)
+	}
+	m.Remove("a")
+	if _, ok := m.Get("a"); ok {
+		t.Error("expected session to be removed")
+	}
+}
@@ -0,0 +1,182 @@
+package protocol
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	defaultVerifyURLs = []string{
+		"http://verify2.xunlei.com/image?t=MVA&cachetime=%d",
+		"http://verify.xunlei.com/image?t=MVA&cachetime=%d",
+		"http://verify3.xunlei.com/image?t=MVA&cachetime=%d",
+	}
+	defaultCookieDomains = []string{
+		"http://xunlei.com",
+		"http://vip.xunlei.com",
+		"http://dynamic.cloud.vip.xunlei.com",
+	}
+)
+
+// Options configures a Session created by NewSessionWithOptions.
+// Zero-valued fields fall back to the live Xunlei hosts, so a stand-in
+// server, a staging mirror or a reverse proxy only needs to override the
+// endpoints it actually serves.
+type Options struct {
+	// Timeout bounds dialing and waiting for response headers.
+	Timeout time.Duration
+	// LoginURL is the base of login.xunlei.com, e.g. "http://login.xunlei.com/".
+	LoginURL string
+	// LixianURL is the base of the lixian web service,
+	// e.g. "http://dynamic.cloud.vip.xunlei.com/".
+	LixianURL string
+	// VerifyURLs are verification image URLs taking a cachetime `%d`;
+	// they are used in rotation.
+	VerifyURLs []string
+	// CookieDomains are the URLs whose cookies are stored by SaveSession
+	// and restored by ResumeSession. The first one must carry the login
+	// cookies (userid, check_result).
+	CookieDomains []string
+	// CaptchaSolver solves image verification codes demanded by the server;
+	// without one such requests fail with ErrCaptchaRequired.
+	CaptchaSolver CaptchaSolver
+	// MaxInFlight bounds the number of concurrent requests; 0 means 4,
+	// a negative value means unlimited.
+	MaxInFlight int
+	// RateLimit is the number of requests per second sent to each host,
+	// with bursts of up to RateBurst; 0 means unlimited.
+	RateLimit float64
+	RateBurst int
+	// PageWorkers is the number of pages of a task list fetched
+	// concurrently; 0 means 4.
+	PageWorkers int
+	// Credentials supplies the account used to log in again when the session
+	// expires before Login has been called, e.g. after ResumeSession.
+	Credentials CredentialsProvider
+	// Retry decides how failed requests are retried; nil means DefaultRetryPolicy.
+	Retry *RetryPolicy
+	// Transport sends the requests of the session, e.g. a Recorder or
+	// a Replayer; nil means a transport dialing with Timeout.
+	Transport http.RoundTripper
+}
+
+func (o *Options) timeout() time.Duration {
+	if o == nil || o.Timeout <= 0 {
+		return 3000 * time.Millisecond
+	}
+	return o.Timeout
+}
+
+func (o *Options) loginURL() string {
+	if o == nil || o.LoginURL == "" {
+		return domainLoginURI
+	}
+	return withTrailingSlash(o.LoginURL)
+}
+
+func (o *Options) lixianURL() string {
+	if o == nil || o.LixianURL == "" {
+		return domainLixianURI
+	}
+	return withTrailingSlash(o.LixianURL)
+}
+
+func (o *Options) verifyURLs() []string {
+	if o == nil || len(o.VerifyURLs) == 0 {
+		return defaultVerifyURLs
+	}
+	return o.VerifyURLs
+}
+
+func (o *Options) captchaSolver() CaptchaSolver {
+	if o == nil {
+		return nil
+	}
+	return o.CaptchaSolver
+}
+
+func (o *Options) maxInFlight() int {
+	if o == nil || o.MaxInFlight == 0 {
+		return 4
+	}
+	return o.MaxInFlight
+}
+
+func (o *Options) pageWorkers() int {
+	if o == nil || o.PageWorkers <= 0 {
+		return 4
+	}
+	return o.PageWorkers
+}
+
+func (o *Options) rateLimit() float64 {
+	if o == nil {
+		return 0
+	}
+	return o.RateLimit
+}
+
+func (o *Options) rateBurst() int {
+	if o == nil {
+		return 0
+	}
+	return o.RateBurst
+}
+
+func (o *Options) credentialsProvider() CredentialsProvider {
+	if o == nil {
+		return nil
+	}
+	return o.Credentials
+}
+
+func (o *Options) retryPolicy() RetryPolicy {
+	if o == nil || o.Retry == nil {
+		return DefaultRetryPolicy
+	}
+	return *o.Retry
+}
+
+func (o *Options) transport() http.RoundTripper {
+	if o == nil || o.Transport == nil {
+		return &http.Transport{
+			Dial:  (&net.Dialer{Timeout: o.timeout()}).Dial,
+			Proxy: http.ProxyFromEnvironment,
+		}
+	}
+	return o.Transport
+}
+
+func (o *Options) cookieURLs() ([]*url.URL, error) {
+	domains := defaultCookieDomains
+	if o != nil && len(o.CookieDomains) > 0 {
+		domains = o.CookieDomains
+	}
+	urls := make([]*url.URL, len(domains))
+	for i := range domains {
+		u, err := url.Parse(domains[i])
+		if err != nil {
+			return nil, err
+		}
+		urls[i] = u
+	}
+	return urls, nil
+}
+
+func withTrailingSlash(s string) string {
+	if strings.HasSuffix(s, "/") {
+		return s
+	}
+	return s + "/"
+}
+
+func newVerifyURLFunc(urls []string) func() string {
+	var counter uint32
+	return func() string {
+		return urls[(atomic.AddUint32(&counter, 1)-1)%uint32(len(urls))]
+	}
+}
@@ -0,0 +1,124 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+)
+
+// tasksPerPage is the number of tasks in a page of showtask_unfresh and
+// user_history, as requested with pageSize.
+var tasksPerPage, _ = strconv.Atoi(pageSize)
+
+type pageFunc func(ctx context.Context, page int) (ts []*Task, more bool, err error)
+
+type pageResult struct {
+	tasks []*Task
+	total int  // of tasks in the list, if reported
+	more  bool // another page follows, if reported
+	err   error
+}
+
+// pageFetcher fetches the pages of a list from the first on, at most workers
+// at a time, and returns them in page order, each as soon as it and those
+// before it have arrived. Pages are fetched ahead of the one asked for up to
+// last, or with no bound if last is 0, until stop is called.
+type pageFetcher struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	fetch   func(ctx context.Context, page int) pageResult
+	workers int
+	next    int
+	last    int
+	stopped bool
+	pending []chan pageResult
+}
+
+func newPageFetcher(ctx context.Context, workers, last int, fetch func(ctx context.Context, page int) pageResult) *pageFetcher {
+	if workers <= 0 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &pageFetcher{ctx: ctx, cancel: cancel, fetch: fetch, workers: workers, next: 1, last: last}
+}
+
+// page returns the next page, issuing the fetches of the pages after it
+// that fit in the workers; ok is false once there are no pages left.
+func (f *pageFetcher) page() (r pageResult, ok bool) {
+	for !f.stopped && len(f.pending) < f.workers && (f.last == 0 || f.next <= f.last) {
+		ch := make(chan pageResult, 1)
+		go func(page int) {
+			ch <- f.fetch(f.ctx, page)
+		}(f.next)
+		f.pending = append(f.pending, ch)
+		f.next++
+	}
+	if len(f.pending) == 0 {
+		return pageResult{}, false
+	}
+	r, f.pending = <-f.pending[0], f.pending[1:]
+	return r, true
+}
+
+// stop cancels the pages being fetched, which are discarded, and issues no
+// more fetches.
+func (f *pageFetcher) stop() {
+	f.stopped = true
+	f.pending = nil
+	f.cancel()
+}
+
+// taskPage fetches a page of the task list of category, and the number of
+// tasks in the category.
+func (s *session) taskPage(ctx context.Context, category, page int) ([]*Task, int, error) {
+	b, err := s.tasklistNofresh(ctx, category, page)
+	if err != nil {
+		return nil, 0, err
+	}
+	var resp taskResponse
+	if err = json.Unmarshal(b, &resp); err != nil {
+		return nil, 0, err
+	}
+	total, _ := strconv.Atoi(resp.Info.User.TotalNum)
+	ts := make([]*Task, len(resp.Info.Tasks))
+	for i := range resp.Info.Tasks {
+		resp.Info.Tasks[i].TaskName = unescapeName(resp.Info.Tasks[i].TaskName)
+		ts[i] = &resp.Info.Tasks[i]
+	}
+	s.cache.pushTasks(ts)
+	return ts, total, nil
+}
+
+// collectTasks returns the tasks of category, and those fetched before
+// an error.
+func (s *session) collectTasks(ctx context.Context, category int, limit ...int) ([]*Task, error) {
+	ts := []*Task{}
+	it := s.IterTasks(ctx, category, limit...)
+	for page := it.NextPage(); page != nil; page = it.NextPage() {
+		ts = append(ts, page...)
+	}
+	return ts, it.Err()
+}
+
+// collectHistory returns the tasks listed by the history pages read by fetch,
+// and those fetched before an error. How many pages there are is only known
+// when the last one is reached, so pages are fetched speculatively, and those
+// after the first page that has no next one are discarded.
+func (s *session) collectHistory(ctx context.Context, fetch pageFunc) ([]*Task, error) {
+	f := newPageFetcher(ctx, s.pageWorkers, 0, func(ctx context.Context, page int) (r pageResult) {
+		r.tasks, r.more, r.err = fetch(ctx, page)
+		return
+	})
+	defer f.stop()
+	ts := make([]*Task, 0, tasksPerPage)
+	for {
+		p, _ := f.page()
+		if p.err != nil {
+			return ts, p.err
+		}
+		ts = append(ts, p.tasks...)
+		if !p.more {
+			return ts, nil
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package protocol
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zyxar/xunlei/protocol/xltest"
+)
+
+func TestPageFetcher(t *testing.T) {
+	var running, peak int32
+	fetch := func(ctx context.Context, page int) (r pageResult) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for p := atomic.LoadInt32(&peak); n > p && !atomic.CompareAndSwapInt32(&peak, p, n); p = atomic.LoadInt32(&peak) {
+		}
+		time.Sleep(time.Duration(8-page%8) * time.Millisecond)
+		r.tasks, r.more = []*Task{{Id: strconv.Itoa(page)}}, page < 7
+		return
+	}
+	f := newPageFetcher(context.Background(), 3, 0, fetch)
+	for page := 1; ; page++ {
+		p, ok := f.page()
+		if !ok || p.err != nil || p.tasks[0].Id != strconv.Itoa(page) {
+			t.Fatalf("unexpected page %d: %+v, %v", page, p, ok)
+		}
+		if !p.more {
+			break
+		}
+	}
+	f.stop()
+	if _, ok := f.page(); ok {
+		t.Error("expected no pages after stop")
+	}
+	if peak > 3 {
+		t.Errorf("expected at most 3 concurrent fetches, got %d", peak)
+	}
+
+	f = newPageFetcher(context.Background(), 2, 5, fetch)
+	n := 0
+	for _, ok := f.page(); ok; _, ok = f.page() {
+		n++
+	}
+	if n != 5 {
+		t.Errorf("expected 5 pages, got %d", n)
+	}
+}
+
+func TestPageFetcherStreams(t *testing.T) {
+	release := make(chan struct{})
+	f := newPageFetcher(context.Background(), 4, 4, func(ctx context.Context, page int) (r pageResult) {
+		if page > 2 {
+			<-release
+		}
+		r.tasks = []*Task{{Id: strconv.Itoa(page)}}
+		return
+	})
+	for page := 1; page <= 2; page++ {
+		done := make(chan pageResult)
+		go func() {
+			p, _ := f.page()
+			done <- p
+		}()
+		select {
+		case p := <-done:
+			if p.tasks[0].Id != strconv.Itoa(page) {
+				t.Fatalf("expected page %d, got %+v", page, p)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected page %d before the pages after it have arrived", page)
+		}
+	}
+	close(release)
+	for page := 3; page <= 4; page++ {
+		if p, ok := f.page(); !ok || p.tasks[0].Id != strconv.Itoa(page) {
+			t.Fatalf("expected page %d, got %+v", page, p)
+		}
+	}
+}
+
+func TestCollectHistoryConcurrently(t *testing.T) {
+	s, srv := newTestSession(t)
+	defer srv.Close()
+	for i := 0; i < 1000; i++ {
+		srv.AddTask(xltest.Task{Name: strconv.Itoa(i), URL: "http://example.com/" + strconv.Itoa(i), Type: xltest.TypeOrdinary, Flag: xltest.FlagDeleted})
+	}
+	srv.SetLatency(10 * time.Millisecond)
+	ts, err := s.GetDeletedTasks()
+	if err != nil || len(ts) != 1000 {
+		t.Fatalf("expected 1000 deleted tasks, got %d, %v", len(ts), err)
+	}
+	for i := range ts {
+		if name := strconv.Itoa(999 - i); ts[i].TaskName != name {
+			t.Fatalf("expected deleted task %s at %d, got %s", name, i, ts[i].TaskName)
+		}
+	}
+	if n := srv.Peak("user_history"); n < 2 || n > s.pageWorkers {
+		t.Errorf("expected up to %d concurrent history requests, got %d", s.pageWorkers, n)
+	}
+}
+
+func TestCollectPages(t *testing.T) {
+	s, srv := newTestSession(t)
+	defer srv.Close()
+	for i := 0; i < 450; i++ {
+		flag := xltest.FlagNormal
+		if i%2 == 0 {
+			flag = xltest.FlagDeleted
+		}
+		srv.AddTask(xltest.Task{Name: strconv.Itoa(i), URL: "http://example.com/" + strconv.Itoa(i), Type: xltest.TypeOrdinary, Flag: flag})
+	}
+	ts, err := s.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 225 {
+		t.Fatalf("expected 225 tasks, got %d", len(ts))
+	}
+	for i := range ts {
+		if name := strconv.Itoa(449 - 2*i); ts[i].TaskName != name {
+			t.Fatalf("expected task %s at %d, got %s", name, i, ts[i].TaskName)
+		}
+	}
+	if n := srv.Requests("showtask_unfresh"); n != 3 {
+		t.Errorf("expected 3 pages to be fetched, got %d", n)
+	}
+	if ts, err = s.GetDeletedTasks(); err != nil || len(ts) != 225 {
+		t.Fatalf("expected 225 deleted tasks, got %d, %v", len(ts), err)
+	}
+	for i := range ts {
+		if name := strconv.Itoa(448 - 2*i); ts[i].TaskName != name {
+			t.Fatalf("expected deleted task %s at %d, got %s", name, i, ts[i].TaskName)
+		}
+	}
+	if ts, err = s.GetExpiredTasks(); err != nil || len(ts) != 0 {
+		t.Errorf("expected no expired tasks, got %d, %v", len(ts), err)
+	}
+}
@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PlayURL is a streaming rendition of a completed task or bt file.
+type PlayURL struct {
+	SpecId     string
+	Resolution string // e.g. "1280x720"
+	URL        string
+	Cookie     string // value of the Cookie header URL must be requested with
+}
+
+// playReply is the reply of get_play_url:
+//
+//	jsonp1457357084473({"rtcode":0,"info":{"cookie":"...","vod_list":[{"spec_id":"225536","width":"1280","height":"720","vod_url":"http://..."}]}})
+type playReply struct {
+	*ErrorMessage
+	Info struct {
+		Cookie  string `json:"cookie"`
+		VodList []struct {
+			SpecId string `json:"spec_id"`
+			Width  string `json:"width"`
+			Height string `json:"height"`
+			VodURL string `json:"vod_url"`
+		} `json:"vod_list"`
+	} `json:"info"`
+}
+
+// GetPlayURLsContext lists the streaming renditions of t, which must be a
+// completed ordinary or ed2k task; bt tasks are played by file with
+// GetBtFilePlayURLsContext.
+func (s *session) GetPlayURLsContext(ctx context.Context, t *Task) ([]PlayURL, error) {
+	if t == nil {
+		return nil, ErrTaskNotFound
+	}
+	if t.IsBt() {
+		return nil, ErrInvalidQuery
+	}
+	if !t.completed() {
+		return nil, ErrTaskNotCompleted
+	}
+	return s.getPlayURLs(ctx, t.URL, t.Cid, t.GCid, t.Size(), t.TaskName)
+}
+
+// GetBtFilePlayURLsContext lists the streaming renditions of the completed
+// file f of a bt task.
+func (s *session) GetBtFilePlayURLsContext(ctx context.Context, f *BtFile) ([]PlayURL, error) {
+	if f == nil {
+		return nil, ErrTaskNotFound
+	}
+	if !f.Completed() {
+		return nil, ErrTaskNotCompleted
+	}
+	return s.getPlayURLs(ctx, f.URL, f.Cid, "", f.Size, f.Name)
+}
+
+func (s *session) getPlayURLs(ctx context.Context, uri, cid, gcid string, size int64, name string) ([]PlayURL, error) {
+	gid, err := s.GetGdriveIdContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v := url.Values{}
+	v.Add("uid", s.userId())
+	v.Add("url", uri)
+	v.Add("cid", cid)
+	v.Add("gcid", gcid)
+	v.Add("filesize", strconv.FormatInt(size, 10))
+	v.Add("filename", name)
+	r, err := s.post(ctx, s.lixianURL(getplayurlURI, currentTimestamp(), currentTimestamp()), v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseCall("get_play_url", r, "jsonp")
+	if err != nil {
+		return nil, err
+	}
+	var reply playReply
+	if err = c.Decode(0, &reply); err != nil {
+		return nil, malformedResponse("get_play_url", r, err)
+	}
+	if code := reply.Rtcode(); code != "0" {
+		return nil, newAPIError("get_play_url", code, reply.Msg(), r, ErrUnexpected)
+	}
+	cookies := []string{"gdriveid=" + gid}
+	if reply.Info.Cookie != "" {
+		cookies = append(cookies, reply.Info.Cookie)
+	}
+	us := make([]PlayURL, 0, len(reply.Info.VodList))
+	for _, vod := range reply.Info.VodList {
+		if vod.VodURL == "" {
+			continue
+		}
+		u := PlayURL{SpecId: vod.SpecId, URL: vod.VodURL, Cookie: strings.Join(cookies, "; ")}
+		if vod.Width != "" && vod.Height != "" {
+			u.Resolution = vod.Width + "x" + vod.Height
+		}
+		us = append(us, u)
+	}
+	return us, nil
+}
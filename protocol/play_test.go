@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/zyxar/xunlei/protocol/xltest"
+)
+
+func TestPlayURLs(t *testing.T) {
+	s, srv := newTestSession(t)
+	defer srv.Close()
+	srv.AddTask(xltest.Task{Name: "done.mkv", URL: "http://example.com/done.mkv", Size: 1 << 30, Type: xltest.TypeOrdinary, Status: xltest.StatusCompleted, Progress: 100})
+	srv.AddTask(xltest.Task{Name: "busy.mkv", URL: "http://example.com/busy.mkv", Size: 1 << 30, Type: xltest.TypeOrdinary, Status: xltest.StatusDownloading})
+	hash := srv.AddTorrent("album", xltest.File{Name: "a.mkv", Size: 1 << 20}, xltest.File{Name: "b.mkv", Size: 1 << 20})
+	if err := s.AddTask(hash); err != nil {
+		t.Fatal(err)
+	}
+	ts, err := s.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.GetPlayURLs(nil); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("expected ErrTaskNotFound, got %v", err)
+	}
+	if _, err = s.GetBtFilePlayURLs(nil); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("expected ErrTaskNotFound, got %v", err)
+	}
+	us, err := s.GetPlayURLs(findTask(ts, "done.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(us) != 2 || us[1].Resolution != "1280x720" || us[1].URL == "" || !strings.HasPrefix(us[1].Cookie, "gdriveid="+xltest.GdriveId+"; vod=") {
+		t.Errorf("unexpected play urls: %+v", us)
+	}
+	if _, err = s.GetPlayURLs(findTask(ts, "busy.mkv")); !errors.Is(err, ErrTaskNotCompleted) {
+		t.Errorf("expected ErrTaskNotCompleted, got %v", err)
+	}
+	bt := findTask(ts, "album")
+	if _, err = s.GetPlayURLs(bt); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery, got %v", err)
+	}
+	srv.UpdateTask(bt.Id, func(t *xltest.Task) { t.Files[1].Status = xltest.StatusCompleted })
+	list, err := s.FillBtList(bt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.GetBtFilePlayURLs(&list.Files[0]); !errors.Is(err, ErrTaskNotCompleted) {
+		t.Errorf("expected ErrTaskNotCompleted, got %v", err)
+	}
+	if us, err = s.GetBtFilePlayURLs(&list.Files[1]); err != nil || len(us) != 2 {
+		t.Errorf("unexpected play urls: %+v, %v", us, err)
+	}
+	list.Files[0].Status = StatusCompleted
+	list.Files[0].URL = "bt://" + hash + "/9"
+	if _, err = s.GetBtFilePlayURLs(&list.Files[0]); !errors.Is(err, ErrUnexpected) {
+		t.Errorf("expected ErrUnexpected, got %v", err)
+	}
+	srv.ReplyNext("get_play_url", 1, `jsonp1457357084473({"info":{}})`)
+	if _, err = s.GetPlayURLs(findTask(ts, "done.mkv")); !errors.Is(err, ErrUnexpected) {
+		t.Errorf("expected a reply without rtcode to be unexpected, got %v", err)
+	}
+}
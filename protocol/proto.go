@@ -1,27 +1,37 @@
 package protocol
 
-func Login(id, passhash string) (err error)       { return defaultSession.Login(id, passhash) }
-func SaveSession(cookieFile string) error         { return defaultSession.SaveSession(cookieFile) }
-func ResumeSession(cookieFile string) (err error) { return defaultSession.ResumeSession(cookieFile) }
-func GetAccount() *UserAccount                    { return defaultSession.Account() }
-func IsOn() bool                                  { return defaultSession.IsOn() }
-func GetTasks(limit ...int) ([]*Task, error)      { return defaultSession.GetTasks(limit...) }
-func GetCompletedTasks() ([]*Task, error)         { return defaultSession.GetCompletedTasks() }
-func GetIncompletedTasks() ([]*Task, error)       { return defaultSession.GetIncompletedTasks() }
-func GetGdriveId() (gid string, err error)        { return defaultSession.GetGdriveId() }
+import (
+	"context"
+	"io"
+)
+
+func Login(id, passhash string) (*LoginResult, error) { return defaultSession.Login(id, passhash) }
+func SaveSession(cookieFile string) error             { return defaultSession.SaveSession(cookieFile) }
+func ResumeSession(cookieFile string) (err error)     { return defaultSession.ResumeSession(cookieFile) }
+func SaveSessionTo(store SessionStore) error          { return defaultSession.SaveSessionTo(store) }
+func ResumeSessionFrom(store SessionStore) error      { return defaultSession.ResumeSessionFrom(store) }
+func GetAccount() *UserAccount                        { return defaultSession.Account() }
+func IsOn() bool                                      { return defaultSession.IsOn() }
+func GetTasks(limit ...int) ([]*Task, error)          { return defaultSession.GetTasks(limit...) }
+func GetCompletedTasks() ([]*Task, error)             { return defaultSession.GetCompletedTasks() }
+func GetIncompletedTasks() ([]*Task, error)           { return defaultSession.GetIncompletedTasks() }
+func GetGdriveId() (gid string, err error)            { return defaultSession.GetGdriveId() }
 func RawTaskList(category, page int) ([]byte, error) {
 	return defaultSession.RawTaskList(category, page)
 }
+func IterTasks(ctx context.Context, category int, limit ...int) *TaskIterator {
+	return defaultSession.IterTasks(ctx, category, limit...)
+}
 func RawTaskListExpired() ([]byte, error)         { return defaultSession.RawTaskListExpired() }
 func RawTaskListDeleted(page int) ([]byte, error) { return defaultSession.RawTaskListDeleted(page) }
 func GetExpiredTasks() ([]*Task, error)           { return defaultSession.GetExpiredTasks() }
 func GetDeletedTasks() ([]*Task, error)           { return defaultSession.GetDeletedTasks() }
 func DelayTask(t *Task) error                     { return defaultSession.DelayTask(t) }
 func DelayTaskById(taskid string) error           { return defaultSession.DelayTaskById(taskid) }
-func FillBtList(t *Task) (*btList, error) {
+func FillBtList(t *Task) (*BtList, error) {
 	return defaultSession.FillBtList(t)
 }
-func FillBtListById(taskid, infohash string) (*btList, error) {
+func FillBtListById(taskid, infohash string) (*BtList, error) {
 	return defaultSession.FillBtListById(taskid, infohash)
 }
 func RawFillBtList(t *Task, page int) ([]byte, error) {
@@ -30,10 +40,32 @@ func RawFillBtList(t *Task, page int) ([]byte, error) {
 func RawFillBtListById(taskid, infohash string, page int) ([]byte, error) {
 	return defaultSession.RawFillBtListById(taskid, infohash, page)
 }
-func AddTask(req string) error { return defaultSession.AddTask(req) }
-func AddBatchTasks(urls []string, oids ...string) error {
+func AddTask(req string, opts ...AddOption) error { return defaultSession.AddTask(req, opts...) }
+func PreviewBtTask(req string, opts ...AddOption) (*BtPreview, error) {
+	return defaultSession.PreviewBtTask(req, opts...)
+}
+func AddTorrent(r io.Reader, opts ...AddOption) (string, string, error) {
+	return defaultSession.AddTorrent(r, opts...)
+}
+func AddTorrentBytes(b []byte, opts ...AddOption) (string, string, error) {
+	return defaultSession.AddTorrentBytes(b, opts...)
+}
+func AddBatchTasks(urls []string, oids ...string) ([]BatchResult, error) {
 	return defaultSession.AddBatchTasks(urls, oids...)
 }
+func GetCategories() ([]Category, error)            { return defaultSession.GetCategories() }
+func AddCategory(name string) (*Category, error)    { return defaultSession.AddCategory(name) }
+func MoveTasks(ids []string, classId int) error     { return defaultSession.MoveTasks(ids, classId) }
+func GetCategoryTasks(classId int) ([]*Task, error) { return defaultSession.GetCategoryTasks(classId) }
+func GetPlayURLs(t *Task) ([]PlayURL, error) {
+	return defaultSession.GetPlayURLs(t)
+}
+func GetBtFilePlayURLs(f *BtFile) ([]PlayURL, error) {
+	return defaultSession.GetBtFilePlayURLs(f)
+}
+func CheckURLs(urls []string) ([]URLCheck, error) {
+	return defaultSession.CheckURLs(urls)
+}
 func ProcessTaskDaemon(ch chan byte, callback TaskCallback) {
 	defaultSession.ProcessTaskDaemon(ch, callback)
 }
@@ -42,11 +74,13 @@ func GetTorrentByHash(hash string) ([]byte, error) { return defaultSession.GetTo
 func GetTorrentFileByHash(hash, file string) error {
 	return defaultSession.GetTorrentFileByHash(hash, file)
 }
-func PauseTask(t *Task) error                  { return defaultSession.PauseTask(t) }
-func PauseTasks(ids []string) error            { return defaultSession.PauseTasks(ids) }
-func DelayAllTasks() error                     { return defaultSession.DelayAllTasks() }
-func ReAddTask(t *Task) error                  { return defaultSession.ReAddTask(t) }
-func ReAddTasks(ts map[string]*Task)           { defaultSession.ReAddTasks(ts) }
+func PauseTask(t *Task) error       { return defaultSession.PauseTask(t) }
+func PauseTasks(ids []string) error { return defaultSession.PauseTasks(ids) }
+func DelayAllTasks() error          { return defaultSession.DelayAllTasks() }
+func ReAddTask(t *Task) error       { return defaultSession.ReAddTask(t) }
+func ReAddTasks(ts map[string]*Task) []BatchResult {
+	return defaultSession.ReAddTasks(ts)
+}
 func RenameTask(t *Task, newname string) error { return defaultSession.RenameTask(t, newname) }
 func RenameTaskById(taskid, newname string) error {
 	return defaultSession.RenameTaskById(taskid, newname)
@@ -65,3 +99,7 @@ func GetTaskById(taskid string) (t *Task, exist bool)    { return defaultSession
 func GetTasksByIds(ids []string) map[string]*Task        { return defaultSession.GetTasksByIds(ids) }
 func InvalidateCache(flag byte)                          { defaultSession.InvalidateCache(flag) }
 func InvalidateCacheAll()                                { defaultSession.InvalidateCacheAll() }
+func SetCaptchaSolver(solver CaptchaSolver)              { defaultSession.SetCaptchaSolver(solver) }
+func SetCredentialsProvider(provider CredentialsProvider) {
+	defaultSession.SetCredentialsProvider(provider)
+}
@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/apex/log"
+)
+
+// CredentialsProvider supplies the account used to renew an expired session
+// that was resumed from cookies rather than logged in by Login.
+type CredentialsProvider func(ctx context.Context) (id, passhash string, err error)
+
+// the lixian pages redirect here once the session cookies are no longer valid.
+var sessionExpiredPattern = regexp.MustCompile(`top.location='[^']*task.html\?error=`)
+
+type noRenewalKey struct{}
+
+// withoutRenewal marks requests that must not trigger a re-login,
+// i.e. those sent by the login itself or probing whether the session is on.
+func withoutRenewal(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRenewalKey{}, true)
+}
+
+func renewable(ctx context.Context) bool {
+	return ctx.Value(noRenewalKey{}) == nil
+}
+
+func (s *session) SetCredentialsProvider(provider CredentialsProvider) {
+	s.lock()
+	s.credentials = provider
+	s.unlock()
+}
+
+// renew logs in again after a request sent at the given epoch found the session
+// expired; if another request has renewed it in the meantime, it does nothing.
+func (s *session) renew(ctx context.Context, epoch int) error {
+	s.authMutex.Lock()
+	defer s.authMutex.Unlock()
+	s.lock()
+	current, id, passhash, provider := s.epoch, s.loginId, s.passhash, s.credentials
+	s.unlock()
+	if current != epoch {
+		return nil
+	}
+	if len(id) == 0 {
+		if provider == nil {
+			return ErrSessionExpired
+		}
+		var err error
+		if id, passhash, err = provider(ctx); err != nil {
+			return err
+		}
+	}
+	log.Info("session expired, logging in again")
+	if _, err := s.login(ctx, id, passhash); err != nil {
+		return err
+	}
+	if s.store != nil {
+		if err := s.saveSession(s.store); err != nil {
+			log.Warnf("save renewed session: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSessionRenewal(t *testing.T) {
+	var logins int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/check":
+			http.SetCookie(w, &http.Cookie{Name: "check_result", Value: "0:abcd", Path: "/"})
+		case "/sec2login/":
+			atomic.AddInt32(&logins, 1)
+			http.SetCookie(w, &http.Cookie{Name: "userid", Value: "42", Path: "/"})
+		case "/login":
+			w.Write([]byte(strings.Repeat("x", 600)))
+		case "/interface/verify_login":
+			w.Write([]byte(`({"result":1,"data":{"userid":"42","nickname":"nick"}})`))
+		default:
+			if c, err := r.Cookie("userid"); err != nil || c.Value != "42" {
+				w.Write([]byte(`<script>top.location='http://cloud.vip.xunlei.com/task.html?error=1'</script>`))
+				return
+			}
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer ts.Close()
+	s, err := newSessionWithOptions(&Options{
+		LoginURL:      ts.URL,
+		LixianURL:     ts.URL,
+		CookieDomains: []string{ts.URL},
+		Credentials: func(ctx context.Context) (string, string, error) {
+			return "me", "pass", nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.get(withoutRenewal(context.Background()), s.lixianURL("user_task")); err != ErrSessionExpired {
+		t.Errorf("expected ErrSessionExpired, got %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := s.get(context.Background(), s.lixianURL("user_task"))
+			if err != nil || string(r) != "ok" {
+				t.Errorf("expected request to be replayed, got %q, %v", r, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&logins); n != 1 {
+		t.Errorf("expected a single login, got %d", n)
+	}
+}
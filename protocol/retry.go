@@ -0,0 +1,101 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy decides whether and when a failed request is sent again.
+// Requests that submit tasks (task_commit, bt_task_commit, batch_task_commit,
+// redownload) are only retried when they cannot have reached the server,
+// so that a task is never submitted twice.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, the first one included;
+	// 1 or less disables retrying.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry; it doubles with every
+	// following attempt, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter is the fraction of each delay, within [0, 1], that is randomised
+	// to keep concurrent clients from retrying in lockstep.
+	Jitter float64
+	// Retryable reports whether an attempt failing with err may be retried;
+	// resp is the response received, if any. Nil means DefaultRetryable.
+	Retryable func(err error, resp *http.Response) bool
+}
+
+// DefaultRetryPolicy is used by sessions whose Options leave Retry unset.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+	Jitter:      0.2,
+}
+
+// DefaultRetryable retries on timeouts, reset or refused connections,
+// truncated bodies, 5xx responses and 429 Too Many Requests. Cancelled
+// requests and other transport errors, such as TLS failures, refused
+// redirects or bad urls, are not retried.
+func DefaultRetryable(err error, resp *http.Response) bool {
+	if resp != nil && (resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests) {
+		return true
+	}
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrTimeout) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (p *RetryPolicy) retryable(err error, resp *http.Response) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err, resp)
+	}
+	return DefaultRetryable(err, resp)
+}
+
+// backoff returns the delay before the given retry, counting from 1.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// notSent reports whether err shows the request never reached the server.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if atomic.AddInt32(&hits, 1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(body)
+	}))
+	defer ts.Close()
+	s, err := newSessionWithOptions(&Options{
+		LixianURL: ts.URL,
+		Retry:     &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.post(context.Background(), s.lixianURL("interface"), "a=1")
+	if err != nil || string(r) != "a=1" {
+		t.Errorf("expected retried post to succeed with its body, got %q, %v", r, err)
+	}
+	if _, err = s.commitForm(context.Background(), s.lixianURL("interface"), "a=1"); err == nil {
+		t.Error("expected commit not to be retried")
+	}
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Errorf("expected 3 requests, got %d", n)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	for retry, want := range []time.Duration{100, 200, 300, 300} {
+		if d := p.backoff(retry + 1); d != want*time.Millisecond {
+			t.Errorf("retry %d: expected %v, got %v", retry+1, want*time.Millisecond, d)
+		}
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		if d := p.backoff(1); d < 50*time.Millisecond || d > 100*time.Millisecond {
+			t.Errorf("jittered delay out of range: %v", d)
+		}
+	}
+}
+
+func TestDefaultRetryable(t *testing.T) {
+	wrap := func(err error) error { return &url.Error{Op: "Get", URL: "http://example.com/", Err: err} }
+	for _, c := range []struct {
+		err  error
+		resp *http.Response
+		want bool
+	}{
+		{nil, &http.Response{StatusCode: http.StatusBadGateway}, true},
+		{nil, &http.Response{StatusCode: http.StatusTooManyRequests}, true},
+		{nil, &http.Response{StatusCode: http.StatusNotFound}, false},
+		{wrap(&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), nil, true},
+		{wrap(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), nil, true},
+		{wrap(io.ErrUnexpectedEOF), nil, true},
+		{wrap(&net.DNSError{Err: "i/o timeout", IsTimeout: true}), nil, true},
+		{wrap(x509.UnknownAuthorityError{}), nil, false},
+		{wrap(errors.New("stopped after 10 redirects")), nil, false},
+		{wrap(context.Canceled), nil, false},
+		{wrap(context.DeadlineExceeded), nil, false},
+		{wrap(io.EOF), nil, false},
+	} {
+		if got := DefaultRetryable(c.err, c.resp); got != c.want {
+			t.Errorf("DefaultRetryable(%v, %v) = %v, want %v", c.err, c.resp, got, c.want)
+		}
+	}
+}
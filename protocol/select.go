@@ -0,0 +1,237 @@
+package protocol
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AddOption configures how AddTask submits a task. The Select options pick
+// the files of a bt task; a file is submitted if it satisfies all of them,
+// and every file is submitted if none is given. Neither they nor WithName
+// apply to ordinary and ed2k tasks; InCategory applies to all tasks. The
+// bean options apply to ordinary and ed2k tasks, which are refused with
+// ErrNeedBean if they cost beans unless one of them accepts the cost.
+type AddOption func(o *addOptions)
+
+type addOptions struct {
+	indexes map[int]bool
+	exps    []*regexp.Regexp
+	exts    map[string]bool
+	minSize int64
+	name    string
+	class   int
+	beans   func(c BeanCost) bool
+}
+
+func newAddOptions(opts []AddOption) *addOptions {
+	o := &addOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// SelectIndexes selects the files of a bt task by index, as reported in
+// BtEntry.Index.
+func SelectIndexes(indexes ...int) AddOption {
+	return func(o *addOptions) {
+		if o.indexes == nil {
+			o.indexes = make(map[int]bool)
+		}
+		for _, i := range indexes {
+			o.indexes[i] = true
+		}
+	}
+}
+
+// SelectMatching selects the files of a bt task whose name matches exp.
+func SelectMatching(exp *regexp.Regexp) AddOption {
+	return func(o *addOptions) {
+		o.exps = append(o.exps, exp)
+	}
+}
+
+// SelectExtensions selects the files of a bt task by extension, given with
+// or without the leading dot and compared case-insensitively.
+func SelectExtensions(exts ...string) AddOption {
+	return func(o *addOptions) {
+		if o.exts == nil {
+			o.exts = make(map[string]bool)
+		}
+		for _, ext := range exts {
+			o.exts[strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+		}
+	}
+}
+
+// SelectMinSize selects the files of a bt task of at least size bytes.
+func SelectMinSize(size int64) AddOption {
+	return func(o *addOptions) {
+		o.minSize = size
+	}
+}
+
+// WithName gives a bt task this name instead of the name of its torrent.
+func WithName(name string) AddOption {
+	return func(o *addOptions) {
+		o.name = name
+	}
+}
+
+// InCategory adds a task into the category classId.
+func InCategory(classId int) AddOption {
+	return func(o *addOptions) {
+		o.class = classId
+	}
+}
+
+// BeanCost is what adding a task costs, as reported before it is committed.
+type BeanCost struct {
+	URL        string
+	Name       string
+	Goldbean   int
+	Silverbean int
+}
+
+// NeverSpendBeans refuses tasks that cost beans, which is the default.
+func NeverSpendBeans() AddOption {
+	return func(o *addOptions) {
+		o.beans = nil
+	}
+}
+
+// SpendBeansUpTo accepts tasks costing at most gold gold beans and silver
+// silver beans.
+func SpendBeansUpTo(gold, silver int) AddOption {
+	return ConfirmBeans(func(c BeanCost) bool {
+		return c.Goldbean <= gold && c.Silverbean <= silver
+	})
+}
+
+// ConfirmBeans asks confirm whether to accept a task that costs beans.
+func ConfirmBeans(confirm func(c BeanCost) bool) AddOption {
+	return func(o *addOptions) {
+		o.beans = confirm
+	}
+}
+
+// spends reports whether o accepts the cost of the task p prepares for uri.
+func (o *addOptions) spends(uri string, p *taskPrepare) bool {
+	if o.beans == nil {
+		return false
+	}
+	c := BeanCost{URL: uri, Name: p.FileName}
+	c.Goldbean, _ = strconv.Atoi(p.Goldbean)
+	c.Silverbean, _ = strconv.Atoi(p.Silverbean)
+	return o.beans(c)
+}
+
+func (o *addOptions) selects(e *BtEntry) bool {
+	if o.indexes != nil && !o.indexes[e.Index] {
+		return false
+	}
+	for _, exp := range o.exps {
+		if !exp.MatchString(e.Name) {
+			return false
+		}
+	}
+	if o.exts != nil && !o.exts[strings.ToLower(strings.TrimPrefix(e.Ext, "."))] {
+		return false
+	}
+	return e.Size >= o.minSize
+}
+
+// BtPreview lists the files of a torrent before the bt task is submitted.
+type BtPreview struct {
+	InfoId string // infohash
+	Name   string
+	Size   int64 // in bytes
+	Files  []BtEntry
+	form   url.Values
+}
+
+// BtEntry is a file of a BtPreview.
+type BtEntry struct {
+	Index    int
+	Name     string
+	Ext      string // without the leading dot
+	Size     int64  // in bytes
+	Picked   bool   // preselected by the server
+	Selected bool   // by the options given to PreviewBtTask
+	findex   string
+}
+
+// Selected returns the files of p selected by the options.
+func (p *BtPreview) Selected() []*BtEntry {
+	files := make([]*BtEntry, 0, len(p.Files))
+	for i := range p.Files {
+		if p.Files[i].Selected {
+			files = append(files, &p.Files[i])
+		}
+	}
+	return files
+}
+
+// SelectedSize returns the total size of the files of p selected by the
+// options.
+func (p *BtPreview) SelectedSize() (size int64) {
+	for i := range p.Files {
+		if p.Files[i].Selected {
+			size += p.Files[i].Size
+		}
+	}
+	return
+}
+
+// apply selects the files of p and names the task as o requires.
+func (p *BtPreview) apply(o *addOptions) {
+	for i := range p.Files {
+		p.Files[i].Selected = o.selects(&p.Files[i])
+	}
+	if o.name != "" {
+		p.Name = o.name
+		p.form.Set("btname", o.name)
+	}
+	if o.class > 0 {
+		p.form.Set("class_id", strconv.Itoa(o.class))
+	}
+}
+
+// commitForm returns the bt_task_commit form submitting the selected files.
+func (p *BtPreview) commitForm() (url.Values, error) {
+	findex := make([]string, 0, len(p.Files))
+	size := make([]string, 0, len(p.Files))
+	for _, f := range p.Selected() {
+		findex = append(findex, f.findex)
+		size = append(size, strconv.FormatInt(f.Size, 10))
+	}
+	if len(findex) == 0 {
+		return nil, ErrNoFilesSelected
+	}
+	v := url.Values{}
+	for k := range p.form {
+		v[k] = append([]string(nil), p.form[k]...)
+	}
+	v.Set("findex", strings.Join(findex, "_"))
+	v.Set("size", strings.Join(size, "_"))
+	return v, nil
+}
+
+func newBtEntry(findex, name, ext, size string, picked bool) BtEntry {
+	e := BtEntry{
+		Name:     unescapeName(strings.Replace(name, `\`, `/`, -1)),
+		Ext:      strings.TrimPrefix(ext, "."),
+		Picked:   picked,
+		Selected: true,
+		findex:   findex,
+	}
+	e.Index, _ = strconv.Atoi(findex)
+	e.Size, _ = strconv.ParseInt(size, 10, 64)
+	if e.Ext == "" {
+		e.Ext = strings.TrimPrefix(path.Ext(e.Name), ".")
+	}
+	return e
+}
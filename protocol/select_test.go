@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/zyxar/xunlei/protocol/xltest"
+)
+
+func TestSelectFiles(t *testing.T) {
+	s, srv := newTestSession(t)
+	defer srv.Close()
+	hash := srv.AddTorrent("season", xltest.File{Name: "e01.mkv", Size: 1 << 30}, xltest.File{Name: "e01.srt", Size: 1 << 10}, xltest.File{Name: "e02.MKV", Size: 1 << 29})
+	p, err := s.PreviewBtTask(hash, SelectExtensions(".mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Files) != 3 || p.Files[1].Name != "e01.srt" || p.Files[1].Size != 1<<10 || !p.Files[1].Picked {
+		t.Fatalf("unexpected preview: %+v", p)
+	}
+	if fs := p.Selected(); len(fs) != 2 || fs[1].Index != 2 || p.SelectedSize() != 3<<29 {
+		t.Errorf("unexpected selection: %+v", fs)
+	}
+	if srv.Requests("bt_task_commit") != 0 {
+		t.Error("expected preview not to submit the task")
+	}
+	if err = s.AddTask(hash, SelectMatching(regexp.MustCompile(`\.srt$`)), SelectMinSize(1<<20)); !errors.Is(err, ErrNoFilesSelected) {
+		t.Errorf("expected ErrNoFilesSelected, got %v", err)
+	}
+	if err = s.AddTask(hash, SelectExtensions("mkv"), SelectMinSize(1<<30)); err != nil {
+		t.Fatal(err)
+	}
+	ts, err := s.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := findTask(ts, "season")
+	if task == nil {
+		t.Fatalf("expected bt task to be listed, got %v", ts)
+	}
+	if got, _ := srv.Task(task.Id); len(got.Files) != 1 || got.Files[0].Name != "e01.mkv" {
+		t.Errorf("unexpected submitted files: %+v", got.Files)
+	}
+	if _, err = s.PreviewBtTask("http://example.com/a.iso"); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestBeanPolicy(t *testing.T) {
+	s, srv := newTestSession(t)
+	defer srv.Close()
+	paid := "http://example.com/paid.iso"
+	srv.SetCost(paid, 2, 1)
+	if err := s.AddTask(paid); !errors.Is(err, ErrNeedBean) {
+		t.Errorf("expected ErrNeedBean, got %v", err)
+	}
+	if err := s.AddTask(paid, SpendBeansUpTo(1, 1)); !errors.Is(err, ErrNeedBean) {
+		t.Errorf("expected ErrNeedBean, got %v", err)
+	}
+	if err := s.AddTask(paid, SpendBeansUpTo(2, 1), NeverSpendBeans()); !errors.Is(err, ErrNeedBean) {
+		t.Errorf("expected ErrNeedBean, got %v", err)
+	}
+	if n := srv.Requests("task_commit"); n != 0 {
+		t.Errorf("expected refused tasks not to be committed, got %d commits", n)
+	}
+	var asked []BeanCost
+	confirm := ConfirmBeans(func(c BeanCost) bool {
+		asked = append(asked, c)
+		return true
+	})
+	if err := s.AddTask("http://example.com/free.iso", confirm); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTask(paid, confirm); err != nil {
+		t.Fatal(err)
+	}
+	if len(asked) != 1 || asked[0] != (BeanCost{paid, "paid.iso", 2, 1}) {
+		t.Errorf("unexpected confirmations: %+v", asked)
+	}
+	if err := s.AddTask("http://example.com/other.iso", SpendBeansUpTo(2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Requests("task_commit"); n != 3 {
+		t.Errorf("expected 3 commits, got %d", n)
+	}
+}
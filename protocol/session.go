@@ -1,19 +1,18 @@
 package protocol
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
-	"net"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -22,340 +21,486 @@ import (
 
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/text"
-	"github.com/zyxar/image2ascii/ascii"
 	"github.com/zyxar/taipei"
+	"github.com/zyxar/xunlei/protocol/jsonp"
 )
 
-var (
-	urlXunleiCom                *url.URL
-	urlVipXunleiCom             *url.URL
-	urlDynamicCloudVipXunleiCom *url.URL
-	defaultSession              Session
-	errInvalidSession           = errors.New("invalid session")
-	errInvalidResponse          = errors.New("invalid response")
-	errInvalidAccount           = errors.New("invalid login account")
-	errUnexpected               = errors.New("unexpected error")
-	errLoginFailed              = errors.New("login failed")
-	errTimeout                  = errors.New("request time out")
-	errSessionExpired           = errors.New("previous session exipred")
-	errBtTaskExisted            = errors.New("bt task already exists")
-	errImageVerification        = errors.New("image verification failed")
-	errNoTasksInProgress        = errors.New("no tasks in progress")
-	errInvalidTaskFlag          = errors.New("invalid flag in task")
-	errTaskNoRedownCap          = errors.New("task not capable for restart")
-	errTaskNotFound             = errors.New("no such taskid in list")
-	errTaskNotCompleted         = errors.New("task not completed")
-	errTaskAlreadyQueued        = errors.New("task already in queue")
-	errTaskAlreadyPurged        = errors.New("task already purged")
-	errTaskAlreadyRemoved       = errors.New("task already deleted")
-	errTaskSubmissionFailed     = errors.New("task submission failed")
-	verifyBaseURLs              = []string{
-		"http://verify2.xunlei.com/image?t=MVA&cachetime=%d",
-		"http://verify.xunlei.com/image?t=MVA&cachetime=%d",
-		"http://verify3.xunlei.com/image?t=MVA&cachetime=%d",
-	}
-)
+var defaultSession Session
 
 func init() {
-	urlXunleiCom, _ = url.Parse("http://xunlei.com")
-	urlVipXunleiCom, _ = url.Parse("http://vip.xunlei.com")
-	urlDynamicCloudVipXunleiCom, _ = url.Parse("http://dynamic.cloud.vip.xunlei.com")
 	defaultSession = newSession(3000 * time.Millisecond)
 	log.SetHandler(text.New(os.Stderr))
 	log.SetLevel(log.InfoLevel)
 }
 
 type Session interface {
-	Login(id, passhash string) (err error)
+	Login(id, passhash string) (*LoginResult, error)
+	LoginContext(ctx context.Context, id, passhash string) (*LoginResult, error)
 	SaveSession(cookieFile string) error
 	ResumeSession(cookieFile string) (err error)
+	ResumeSessionContext(ctx context.Context, cookieFile string) (err error)
+	SaveSessionTo(store SessionStore) error
+	ResumeSessionFrom(store SessionStore) error
+	ResumeSessionFromContext(ctx context.Context, store SessionStore) error
 	Account() (ua *UserAccount)
 	IsOn() bool
+	IsOnContext(ctx context.Context) bool
 	GetTasks(limit ...int) ([]*Task, error)
+	GetTasksContext(ctx context.Context, limit ...int) ([]*Task, error)
 	GetCompletedTasks() ([]*Task, error)
+	GetCompletedTasksContext(ctx context.Context) ([]*Task, error)
 	GetIncompletedTasks() ([]*Task, error)
+	GetIncompletedTasksContext(ctx context.Context) ([]*Task, error)
+	IterTasks(ctx context.Context, category int, limit ...int) *TaskIterator
 	GetGdriveId() (gid string, err error)
+	GetGdriveIdContext(ctx context.Context) (gid string, err error)
 	RawTaskList(category, page int) ([]byte, error)
+	RawTaskListContext(ctx context.Context, category, page int) ([]byte, error)
 	RawTaskListExpired() ([]byte, error)
+	RawTaskListExpiredContext(ctx context.Context) ([]byte, error)
 	RawTaskListDeleted(page int) ([]byte, error)
+	RawTaskListDeletedContext(ctx context.Context, page int) ([]byte, error)
 	GetExpiredTasks() ([]*Task, error)
+	GetExpiredTasksContext(ctx context.Context) ([]*Task, error)
 	GetDeletedTasks() ([]*Task, error)
+	GetDeletedTasksContext(ctx context.Context) ([]*Task, error)
 	DelayTask(t *Task) error
+	DelayTaskContext(ctx context.Context, t *Task) error
 	DelayTaskById(taskid string) error
-	FillBtList(t *Task) (*btList, error)
-	FillBtListById(taskid, infohash string) (*btList, error)
+	DelayTaskByIdContext(ctx context.Context, taskid string) error
+	FillBtList(t *Task) (*BtList, error)
+	FillBtListContext(ctx context.Context, t *Task) (*BtList, error)
+	FillBtListById(taskid, infohash string) (*BtList, error)
+	FillBtListByIdContext(ctx context.Context, taskid, infohash string) (*BtList, error)
 	RawFillBtList(t *Task, page int) ([]byte, error)
+	RawFillBtListContext(ctx context.Context, t *Task, page int) ([]byte, error)
 	RawFillBtListById(taskid, infohash string, page int) ([]byte, error)
-	AddTask(req string) error
-	AddBatchTasks(urls []string, oids ...string) error
+	RawFillBtListByIdContext(ctx context.Context, taskid, infohash string, page int) ([]byte, error)
+	AddTask(req string, opts ...AddOption) error
+	AddTaskContext(ctx context.Context, req string, opts ...AddOption) error
+	PreviewBtTask(req string, opts ...AddOption) (*BtPreview, error)
+	PreviewBtTaskContext(ctx context.Context, req string, opts ...AddOption) (*BtPreview, error)
+	AddTorrent(r io.Reader, opts ...AddOption) (taskid, infohash string, err error)
+	AddTorrentContext(ctx context.Context, r io.Reader, opts ...AddOption) (taskid, infohash string, err error)
+	AddTorrentBytes(b []byte, opts ...AddOption) (taskid, infohash string, err error)
+	AddTorrentBytesContext(ctx context.Context, b []byte, opts ...AddOption) (taskid, infohash string, err error)
+	AddBatchTasks(urls []string, oids ...string) ([]BatchResult, error)
+	GetCategories() ([]Category, error)
+	GetCategoriesContext(ctx context.Context) ([]Category, error)
+	AddCategory(name string) (*Category, error)
+	AddCategoryContext(ctx context.Context, name string) (*Category, error)
+	MoveTasks(ids []string, classId int) error
+	MoveTasksContext(ctx context.Context, ids []string, classId int) error
+	GetCategoryTasks(classId int) ([]*Task, error)
+	GetCategoryTasksContext(ctx context.Context, classId int) ([]*Task, error)
+	GetPlayURLs(t *Task) ([]PlayURL, error)
+	GetPlayURLsContext(ctx context.Context, t *Task) ([]PlayURL, error)
+	GetBtFilePlayURLs(f *BtFile) ([]PlayURL, error)
+	GetBtFilePlayURLsContext(ctx context.Context, f *BtFile) ([]PlayURL, error)
+	CheckURLs(urls []string) ([]URLCheck, error)
+	CheckURLsContext(ctx context.Context, urls []string) ([]URLCheck, error)
+	AddBatchTasksContext(ctx context.Context, urls []string, oids ...string) ([]BatchResult, error)
 	ProcessTaskDaemon(ch chan byte, callback TaskCallback)
+	ProcessTaskDaemonContext(ctx context.Context, ch chan byte, callback TaskCallback)
 	ProcessTask(callback TaskCallback) error
+	ProcessTaskContext(ctx context.Context, callback TaskCallback) error
 	GetTorrentByHash(hash string) ([]byte, error)
+	GetTorrentByHashContext(ctx context.Context, hash string) ([]byte, error)
 	GetTorrentFileByHash(hash, file string) error
+	GetTorrentFileByHashContext(ctx context.Context, hash, file string) error
 	PauseTask(t *Task) error
+	PauseTaskContext(ctx context.Context, t *Task) error
 	PauseTasks(ids []string) error
+	PauseTasksContext(ctx context.Context, ids []string) error
 	DelayAllTasks() error
+	DelayAllTasksContext(ctx context.Context) error
 	ReAddTask(t *Task) error
-	ReAddTasks(ts map[string]*Task)
+	ReAddTaskContext(ctx context.Context, t *Task) error
+	ReAddTasks(ts map[string]*Task) []BatchResult
+	ReAddTasksContext(ctx context.Context, ts map[string]*Task) []BatchResult
 	RenameTask(t *Task, newname string) error
+	RenameTaskContext(ctx context.Context, t *Task, newname string) error
 	RenameTaskById(taskid, newname string) error
+	RenameTaskByIdContext(ctx context.Context, taskid, newname string) error
 	ResumeTask(t *Task) error
+	ResumeTaskContext(ctx context.Context, t *Task) error
 	ResumeTaskById(taskid string) error
+	ResumeTaskByIdContext(ctx context.Context, taskid string) error
 	DeleteTask(t *Task) error
+	DeleteTaskContext(ctx context.Context, t *Task) error
 	DeleteTaskById(taskid string) error
+	DeleteTaskByIdContext(ctx context.Context, taskid string) error
 	PurgeTask(t *Task) error
+	PurgeTaskContext(ctx context.Context, t *Task) error
 	PurgeTaskById(taskid string) error
+	PurgeTaskByIdContext(ctx context.Context, taskid string) error
 	VerifyTask(t *Task, path string) bool
+	VerifyTaskContext(ctx context.Context, t *Task, path string) bool
 	FindTasks(pattern string) (map[string]*Task, error)
 	GetTaskById(taskid string) (t *Task, exist bool)
 	GetTasksByIds(ids []string) map[string]*Task
 	InvalidateCache(flag byte)
 	InvalidateCacheAll()
+	SetCaptchaSolver(solver CaptchaSolver)
+	SetCredentialsProvider(provider CredentialsProvider)
 }
 
 type session struct {
 	*http.Client
-	mutex       *sync.Mutex
+	jar         *jar
+	mutex       *sync.Mutex // guards uid, gid, account, accountInfo and the credentials
+	authMutex   *sync.Mutex // serializes login and cookie persistence; guards store
+	throttle    *throttle
+	retry       RetryPolicy
+	pageWorkers int
 	cache       *cache
 	account     *UserAccount
 	accountInfo *UserInfo
 	uid         string
 	gid         string
 	timeout     time.Duration
+	loginURI    string
+	lixianURI   string
+	cookieURLs  []*url.URL
+	verifyURL   func() string
+	solver      CaptchaSolver
+	credentials CredentialsProvider
+	loginId     string
+	passhash    string
+	epoch       int          // counts logins, so that concurrent renewals log in only once
+	store       SessionStore // where the session was last saved to or resumed from
 }
 
 func NewSession(timeout time.Duration) Session {
 	return newSession(timeout)
 }
 
+// NewSessionWithOptions creates a Session talking to the endpoints given in opts;
+// a nil opts is the same as NewSession with the default timeout.
+func NewSessionWithOptions(opts *Options) (Session, error) {
+	return newSessionWithOptions(opts)
+}
+
 func newSession(timeout time.Duration) *session {
-	jar, _ := cookiejar.New(nil)
+	s, _ := newSessionWithOptions(&Options{Timeout: timeout})
+	return s
+}
+
+func newSessionWithOptions(opts *Options) (*session, error) {
+	cookieURLs, err := opts.cookieURLs()
+	if err != nil {
+		return nil, err
+	}
+	timeout := opts.timeout()
+	jar := newJar()
 	return &session{
+		jar:     jar,
 		timeout: timeout,
 		Client: &http.Client{
-			Jar: jar,
-			Transport: &http.Transport{
-				Dial:  (&net.Dialer{Timeout: timeout}).Dial,
-				Proxy: http.ProxyFromEnvironment,
-			},
+			Jar:       jar,
+			Transport: opts.transport(),
 		},
-		mutex: &sync.Mutex{},
-		cache: newCache(),
-	}
+		mutex:       &sync.Mutex{},
+		authMutex:   &sync.Mutex{},
+		throttle:    newThrottle(opts.maxInFlight(), opts.rateLimit(), opts.rateBurst()),
+		retry:       opts.retryPolicy(),
+		pageWorkers: opts.pageWorkers(),
+		cache:       newCache(),
+		loginURI:    opts.loginURL(),
+		lixianURI:   opts.lixianURL(),
+		cookieURLs:  cookieURLs,
+		verifyURL:   newVerifyURLFunc(opts.verifyURLs()),
+		solver:      opts.captchaSolver(),
+		credentials: opts.credentialsProvider(),
+	}, nil
+}
+
+func (s *session) Login(id, passhash string) (*LoginResult, error) {
+	return s.LoginContext(context.Background(), id, passhash)
+}
+func (s *session) ResumeSession(cookieFile string) (err error) {
+	return s.ResumeSessionContext(context.Background(), cookieFile)
+}
+func (s *session) ResumeSessionFrom(store SessionStore) error {
+	return s.ResumeSessionFromContext(context.Background(), store)
+}
+func (s *session) IsOn() bool { return s.IsOnContext(context.Background()) }
+func (s *session) GetTasks(limit ...int) ([]*Task, error) {
+	return s.GetTasksContext(context.Background(), limit...)
+}
+func (s *session) GetCompletedTasks() ([]*Task, error) {
+	return s.GetCompletedTasksContext(context.Background())
+}
+func (s *session) GetIncompletedTasks() ([]*Task, error) {
+	return s.GetIncompletedTasksContext(context.Background())
+}
+func (s *session) GetGdriveId() (gid string, err error) {
+	return s.GetGdriveIdContext(context.Background())
+}
+func (s *session) RawTaskList(category, page int) ([]byte, error) {
+	return s.RawTaskListContext(context.Background(), category, page)
+}
+func (s *session) RawTaskListExpired() ([]byte, error) {
+	return s.RawTaskListExpiredContext(context.Background())
+}
+func (s *session) RawTaskListDeleted(page int) ([]byte, error) {
+	return s.RawTaskListDeletedContext(context.Background(), page)
+}
+func (s *session) GetExpiredTasks() ([]*Task, error) {
+	return s.GetExpiredTasksContext(context.Background())
+}
+func (s *session) GetDeletedTasks() ([]*Task, error) {
+	return s.GetDeletedTasksContext(context.Background())
+}
+func (s *session) DelayTask(t *Task) error { return s.DelayTaskContext(context.Background(), t) }
+func (s *session) DelayTaskById(taskid string) error {
+	return s.DelayTaskByIdContext(context.Background(), taskid)
+}
+func (s *session) FillBtList(t *Task) (*BtList, error) {
+	return s.FillBtListContext(context.Background(), t)
+}
+func (s *session) FillBtListById(taskid, infohash string) (*BtList, error) {
+	return s.FillBtListByIdContext(context.Background(), taskid, infohash)
+}
+func (s *session) RawFillBtList(t *Task, page int) ([]byte, error) {
+	return s.RawFillBtListContext(context.Background(), t, page)
+}
+func (s *session) RawFillBtListById(taskid, infohash string, page int) ([]byte, error) {
+	return s.RawFillBtListByIdContext(context.Background(), taskid, infohash, page)
+}
+func (s *session) AddTask(req string, opts ...AddOption) error {
+	return s.AddTaskContext(context.Background(), req, opts...)
+}
+func (s *session) PreviewBtTask(req string, opts ...AddOption) (*BtPreview, error) {
+	return s.PreviewBtTaskContext(context.Background(), req, opts...)
+}
+func (s *session) AddTorrent(r io.Reader, opts ...AddOption) (string, string, error) {
+	return s.AddTorrentContext(context.Background(), r, opts...)
+}
+func (s *session) AddTorrentBytes(b []byte, opts ...AddOption) (string, string, error) {
+	return s.AddTorrentBytesContext(context.Background(), b, opts...)
+}
+func (s *session) AddBatchTasks(urls []string, oids ...string) ([]BatchResult, error) {
+	return s.AddBatchTasksContext(context.Background(), urls, oids...)
+}
+func (s *session) GetCategories() ([]Category, error) {
+	return s.GetCategoriesContext(context.Background())
+}
+func (s *session) AddCategory(name string) (*Category, error) {
+	return s.AddCategoryContext(context.Background(), name)
+}
+func (s *session) MoveTasks(ids []string, classId int) error {
+	return s.MoveTasksContext(context.Background(), ids, classId)
+}
+func (s *session) GetCategoryTasks(classId int) ([]*Task, error) {
+	return s.GetCategoryTasksContext(context.Background(), classId)
+}
+func (s *session) GetPlayURLs(t *Task) ([]PlayURL, error) {
+	return s.GetPlayURLsContext(context.Background(), t)
+}
+func (s *session) GetBtFilePlayURLs(f *BtFile) ([]PlayURL, error) {
+	return s.GetBtFilePlayURLsContext(context.Background(), f)
+}
+func (s *session) CheckURLs(urls []string) ([]URLCheck, error) {
+	return s.CheckURLsContext(context.Background(), urls)
+}
+func (s *session) ProcessTaskDaemon(ch chan byte, callback TaskCallback) {
+	s.ProcessTaskDaemonContext(context.Background(), ch, callback)
+}
+func (s *session) ProcessTask(callback TaskCallback) error {
+	return s.ProcessTaskContext(context.Background(), callback)
+}
+func (s *session) GetTorrentByHash(hash string) ([]byte, error) {
+	return s.GetTorrentByHashContext(context.Background(), hash)
+}
+func (s *session) GetTorrentFileByHash(hash, file string) error {
+	return s.GetTorrentFileByHashContext(context.Background(), hash, file)
+}
+func (s *session) PauseTask(t *Task) error { return s.PauseTaskContext(context.Background(), t) }
+func (s *session) PauseTasks(ids []string) error {
+	return s.PauseTasksContext(context.Background(), ids)
+}
+func (s *session) DelayAllTasks() error    { return s.DelayAllTasksContext(context.Background()) }
+func (s *session) ReAddTask(t *Task) error { return s.ReAddTaskContext(context.Background(), t) }
+func (s *session) ReAddTasks(ts map[string]*Task) []BatchResult {
+	return s.ReAddTasksContext(context.Background(), ts)
+}
+func (s *session) RenameTask(t *Task, newname string) error {
+	return s.RenameTaskContext(context.Background(), t, newname)
+}
+func (s *session) RenameTaskById(taskid, newname string) error {
+	return s.RenameTaskByIdContext(context.Background(), taskid, newname)
+}
+func (s *session) ResumeTask(t *Task) error { return s.ResumeTaskContext(context.Background(), t) }
+func (s *session) ResumeTaskById(taskid string) error {
+	return s.ResumeTaskByIdContext(context.Background(), taskid)
+}
+func (s *session) DeleteTask(t *Task) error { return s.DeleteTaskContext(context.Background(), t) }
+func (s *session) DeleteTaskById(taskid string) error {
+	return s.DeleteTaskByIdContext(context.Background(), taskid)
+}
+func (s *session) PurgeTask(t *Task) error { return s.PurgeTaskContext(context.Background(), t) }
+func (s *session) PurgeTaskById(taskid string) error {
+	return s.PurgeTaskByIdContext(context.Background(), taskid)
+}
+func (s *session) VerifyTask(t *Task, path string) bool {
+	return s.VerifyTaskContext(context.Background(), t, path)
 }
 
-func (s *session) Login(id, passhash string) (err error) {
-	var vcode string
+func (s *session) LoginContext(ctx context.Context, id, passhash string) (*LoginResult, error) {
 	if len(id) == 0 {
-		err = errInvalidAccount
-		return
-	}
-	loginURL := fmt.Sprintf("http://login.xunlei.com/check?u=%s&cachetime=%d", id, currentTimestamp())
-loop:
-	if _, err = s.get(loginURL); err != nil {
-		return
+		return nil, ErrInvalidAccount
 	}
-	cks := s.Client.Jar.Cookies(urlXunleiCom)
-	for i := range cks {
-		if cks[i].Name == "check_result" {
-			if len(cks[i].Value) < 3 {
-				goto loop
+	s.authMutex.Lock()
+	defer s.authMutex.Unlock()
+	return s.login(ctx, id, passhash)
+}
+
+// login must be called with authMutex held.
+func (s *session) login(ctx context.Context, id, passhash string) (*LoginResult, error) {
+	ctx = withoutRenewal(ctx)
+	for attempt := 0; attempt < loginAttempts; attempt++ {
+		vcode, err := s.loginCheck(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(vcode) == 0 {
+			continue
+		}
+		v := url.Values{}
+		v.Set("u", id)
+		v.Set("p", hashPass(passhash, vcode))
+		v.Set("verifycode", vcode)
+		if _, err = s.post(ctx, s.loginURI+loginsubmitURI, v.Encode()); err != nil {
+			return nil, err
+		}
+		uid := s.getCookie("userid")
+		s.setUserId(uid)
+		log.Infof("uid: %s\n", uid)
+		if len(uid) == 0 {
+			if result := s.getCookie("blogresult"); result != loginResultBadVerifyCode {
+				return nil, &APIError{Endpoint: "sec2login", Code: result, Err: ErrLoginFailed}
 			}
-			vcode = cks[i].Value[2:]
-			vcode = strings.ToUpper(vcode)
-			log.Infof("verify_code: %s", vcode)
-			break
+			continue
 		}
+		var r []byte
+		if r, err = s.get(ctx, s.lixianURL(loginURI, currentTimestamp())); err != nil {
+			return nil, err
+		}
+		if len(r) < 512 {
+			return nil, unexpectedResponse("login", r)
+		}
+		result, err := s.verifyLogin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.lock()
+		s.loginId, s.passhash = id, EncryptPass(passhash)
+		s.epoch++
+		s.unlock()
+		return result, nil
 	}
-	v := url.Values{}
-	v.Set("u", id)
-	v.Set("p", hashPass(passhash, vcode))
-	v.Set("verifycode", vcode)
-	if _, err = s.post("http://login.xunlei.com/sec2login/", v.Encode()); err != nil {
-		return
-	}
-	s.uid = s.getCookie("userid")
-	log.Infof("uid: %s\n", s.uid)
-	if len(s.uid) == 0 {
-		err = errLoginFailed
-		return
-	}
-	var r []byte
-	if r, err = s.get(fmt.Sprintf("%slogin?cachetime=%d&from=0", domainLixianURI, currentTimestamp())); err != nil || len(r) < 512 {
-		err = errUnexpected
-	}
-	return
+	return nil, fmt.Errorf("%w: gave up after %d attempts", ErrLoginFailed, loginAttempts)
 }
 
+// SaveSession saves the session to cookieFile, see NewFileStore.
 func (s *session) SaveSession(cookieFile string) error {
-	session := [][]*http.Cookie{
-		s.Client.Jar.Cookies(urlXunleiCom),
-		s.Client.Jar.Cookies(urlVipXunleiCom),
-		s.Client.Jar.Cookies(urlDynamicCloudVipXunleiCom)}
-	r, err := json.MarshalIndent(session, "", "  ")
-	if err != nil {
+	return s.SaveSessionTo(NewFileStore(cookieFile))
+}
+
+func (s *session) SaveSessionTo(store SessionStore) error {
+	s.authMutex.Lock()
+	defer s.authMutex.Unlock()
+	return s.saveSession(store)
+}
+
+// saveSession must be called with authMutex held.
+func (s *session) saveSession(store SessionStore) error {
+	if err := store.Save(s.state()); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(cookieFile, r, 0644)
+	s.store = store
+	return nil
 }
 
-func (s *session) ResumeSession(cookieFile string) (err error) {
-	if cookieFile != "" {
-		var data []byte
-		data, err = ioutil.ReadFile(cookieFile)
-		if err != nil {
-			return
+// ResumeSessionContext resumes the session saved in cookieFile, see NewFileStore;
+// with an empty cookieFile it only checks whether the current session is on.
+func (s *session) ResumeSessionContext(ctx context.Context, cookieFile string) error {
+	if cookieFile == "" {
+		if !s.IsOnContext(ctx) {
+			return ErrSessionExpired
 		}
-		var session [][]*http.Cookie
-		if err = json.Unmarshal(data, &session); err != nil {
-			return
-		}
-		if len(session) < 2 {
-			err = errInvalidSession
-			return
-		}
-		s.Client.Jar.SetCookies(urlXunleiCom, session[0])
-		s.Client.Jar.SetCookies(urlVipXunleiCom, session[1])
-		s.Client.Jar.SetCookies(urlDynamicCloudVipXunleiCom, session[2])
+		return nil
 	}
-	if !s.IsOn() {
-		err = errSessionExpired
+	return s.ResumeSessionFromContext(ctx, NewFileStore(cookieFile))
+}
+
+func (s *session) ResumeSessionFromContext(ctx context.Context, store SessionStore) error {
+	s.authMutex.Lock()
+	defer s.authMutex.Unlock()
+	state, err := store.Load()
+	if err != nil {
+		return err
 	}
-	return
+	s.restore(state)
+	s.store = store
+	if !s.IsOnContext(ctx) {
+		return ErrSessionExpired
+	}
+	return nil
 }
 
 func (s *session) Account() (ua *UserAccount) {
+	s.lock()
+	defer s.unlock()
 	return s.account
 }
 
-func (s *session) IsOn() bool {
+func (s *session) IsOnContext(ctx context.Context) bool {
 	uid := s.getCookie("userid")
 	if len(uid) == 0 {
 		return false
 	}
-	r, err := s.get(fmt.Sprintf(taskHomeURI, uid))
-	if err != nil {
-		return false
-	}
-	if ok, _ := regexp.Match(`top.location='http://cloud.vip.xunlei.com/task.html\?error=`, r); ok {
+	if _, err := s.get(withoutRenewal(ctx), s.lixianURL(taskHomeURI, uid)); err != nil {
 		return false
 	}
+	s.lock()
 	if len(s.uid) == 0 {
 		s.uid = uid
 	}
+	s.unlock()
 	return true
 }
 
-func (s *session) GetTasks(limit ...int) ([]*Task, error) {
-	accumulated := 0
-	page := 1
-	var ts []*Task
-round:
-	b, err := s.tasklistNofresh(statusMixed, page)
-	if err != nil {
-		return ts, err
-	}
-	var resp taskResponse
-	err = json.Unmarshal(b, &resp)
+func (s *session) GetTasksContext(ctx context.Context, limit ...int) ([]*Task, error) {
+	ts, err := s.collectTasks(ctx, CategoryAll, limit...)
 	if err != nil {
 		return ts, err
 	}
-	total, _ := strconv.Atoi(resp.Info.User.TotalNum)
-	if ts == nil {
-		if total <= 0 {
-			ts = make([]*Task, 0, len(resp.Info.Tasks))
-		} else {
-			ts = make([]*Task, 0, total)
-		}
-	}
-	for i := range resp.Info.Tasks {
-		resp.Info.Tasks[i].TaskName = unescapeName(resp.Info.Tasks[i].TaskName)
-		ts = append(ts, &resp.Info.Tasks[i])
-	}
-	accumulated += len(ts)
-	if accumulated < total && (len(limit) == 0 || accumulated < limit[0]) {
-		page++
-		goto round
-	}
 	s.cache.InvalidateGroup(flagNormal)
 	s.cache.pushTasks(ts)
-	return ts, err
+	return ts, nil
 }
 
-func (s *session) GetCompletedTasks() ([]*Task, error) {
-	accumulated := 0
-	page := 1
-	var ts []*Task
-round:
-	b, err := s.tasklistNofresh(statusCompleted, page)
-	if err != nil {
-		return ts, err
-	}
-	var resp taskResponse
-	err = json.Unmarshal(b, &resp)
-	if err != nil {
-		return ts, err
-	}
-	total, _ := strconv.Atoi(resp.Info.User.TotalNum)
-	if ts == nil {
-		if total <= 0 {
-			ts = make([]*Task, 0, len(resp.Info.Tasks))
-		} else {
-			ts = make([]*Task, 0, total)
-		}
-	}
-	for i := range resp.Info.Tasks {
-		resp.Info.Tasks[i].TaskName = unescapeName(resp.Info.Tasks[i].TaskName)
-		ts = append(ts, &resp.Info.Tasks[i])
-	}
-	accumulated += len(ts)
-	if accumulated < total {
-		page++
-		goto round
-	}
-	s.cache.pushTasks(ts)
-	return ts, err
+func (s *session) GetCompletedTasksContext(ctx context.Context) ([]*Task, error) {
+	return s.collectTasks(ctx, CategoryCompleted)
 }
 
-func (s *session) GetIncompletedTasks() ([]*Task, error) {
-	accumulated := 0
-	page := 1
-	var ts []*Task
-round:
-	b, err := s.tasklistNofresh(statusDownloading, page)
+func (s *session) GetIncompletedTasksContext(ctx context.Context) ([]*Task, error) {
+	ts, err := s.collectTasks(ctx, CategoryIncompleted)
 	if err != nil {
 		return nil, err
 	}
-	var resp taskResponse
-	err = json.Unmarshal(b, &resp)
-	if err != nil {
-		return nil, err
-	}
-	total, _ := strconv.Atoi(resp.Info.User.TotalNum)
-	if ts == nil {
-		if total <= 0 {
-			ts = make([]*Task, 0, len(resp.Info.Tasks))
-		} else {
-			ts = make([]*Task, 0, total)
-		}
-	}
-	for i := range resp.Info.Tasks {
-		resp.Info.Tasks[i].TaskName = unescapeName(resp.Info.Tasks[i].TaskName)
-		ts = append(ts, &resp.Info.Tasks[i])
-	}
-	accumulated += len(ts)
-	if accumulated < total {
-		page++
-		goto round
-	}
-	s.cache.pushTasks(ts)
-	return ts, err
+	return ts, nil
 }
 
-func (s *session) GetGdriveId() (gid string, err error) {
-	if len(s.gid) == 0 {
+func (s *session) GetGdriveIdContext(ctx context.Context) (gid string, err error) {
+	s.lock()
+	gid = s.gid
+	s.unlock()
+	if len(gid) == 0 {
 		var b []byte
-		b, err = s.tasklistNofresh(statusMixed, 1)
+		b, err = s.tasklistNofresh(ctx, CategoryAll, 1)
 		if err != nil {
 			return
 		}
@@ -364,72 +509,73 @@ func (s *session) GetGdriveId() (gid string, err error) {
 		if err != nil {
 			return
 		}
-		s.gid = resp.Info.User.Cookie
+		gid = resp.Info.User.Cookie
+		s.lock()
+		s.gid = gid
 		s.account = &resp.Info.User
 		s.accountInfo = &resp.UserInfo
-		log.Debugf("gdriveid: %s", s.gid)
+		s.unlock()
+		log.Debugf("gdriveid: %s", gid)
 	}
-	gid = s.gid
 	return
 }
 
 // bellow three funcs are for RESTful calling;
 // we do not parse content here.
-func (s *session) RawTaskList(category, page int) ([]byte, error) {
-	return s.tasklistNofresh(category, page)
+func (s *session) RawTaskListContext(ctx context.Context, category, page int) ([]byte, error) {
+	return s.tasklistNofresh(ctx, category, page)
 }
 
-func (s *session) RawTaskListExpired() ([]byte, error) {
-	return s.readExpired()
+func (s *session) RawTaskListExpiredContext(ctx context.Context) ([]byte, error) {
+	return s.readExpired(ctx, 0)
 }
 
-func (s *session) RawTaskListDeleted(page int) ([]byte, error) {
-	return s.readHistory(page)
+func (s *session) RawTaskListDeletedContext(ctx context.Context, page int) ([]byte, error) {
+	return s.readHistory(ctx, page)
 }
 
-func (s *session) GetExpiredTasks() ([]*Task, error) {
-	r, err := s.readExpired()
-	ts, _ := parseHistory(r, "4")
+func (s *session) GetExpiredTasksContext(ctx context.Context) ([]*Task, error) {
+	ts, err := s.collectHistory(ctx, func(ctx context.Context, page int) ([]*Task, bool, error) {
+		r, err := s.readExpired(ctx, page)
+		if err != nil {
+			return nil, false, err
+		}
+		return parseHistory(r, "4")
+	})
 	s.cache.InvalidateGroup(flagExpired)
 	s.cache.pushTasks(ts)
 	return ts, err
 }
 
-func (s *session) GetDeletedTasks() ([]*Task, error) {
-	j := 0
-	next := true
-	var err error
-	var r []byte
-	var ts []*Task
-	tss := make([]*Task, 0, 10)
-	for next {
-		j++
-		r, err = s.readHistory(j)
-		ts, next = parseHistory(r, "1")
-		tss = append(tss, ts...)
-	}
+func (s *session) GetDeletedTasksContext(ctx context.Context) ([]*Task, error) {
+	ts, err := s.collectHistory(ctx, func(ctx context.Context, page int) ([]*Task, bool, error) {
+		r, err := s.readHistory(ctx, page)
+		if err != nil {
+			return nil, false, err
+		}
+		return parseHistory(r, "1")
+	})
 	s.cache.InvalidateGroup(flagDeleted)
 	s.cache.InvalidateGroup(flagPurged)
-	s.cache.pushTasks(tss)
-	return tss, err
+	s.cache.pushTasks(ts)
+	return ts, err
 }
 
-func (s *session) DelayTask(t *Task) error {
+func (s *session) DelayTaskContext(ctx context.Context, t *Task) error {
 	if t == nil {
-		return errTaskNotFound
+		return ErrTaskNotFound
 	}
-	return s.DelayTaskById(t.Id)
+	return s.DelayTaskByIdContext(ctx, t.Id)
 }
 
-func (s *session) DelayTaskById(taskid string) error {
-	r, err := s.get(fmt.Sprintf(taskdelayURI, taskid+"_1", "task", currentTimestamp()))
+func (s *session) DelayTaskByIdContext(ctx context.Context, taskid string) error {
+	r, err := s.get(ctx, s.lixianURL(taskdelayURI, taskid+"_1", "task", currentTimestamp()))
 	if err != nil {
 		return err
 	}
-	exp := regexp.MustCompile(`^task_delay_resp\((.*}),\[.*\]\)`)
-	sub := exp.FindSubmatch(r)
-	if sub == nil {
-		return errInvalidResponse
+	c, err := parseCall("task_delay", r, "task_delay_resp")
+	if err != nil {
+		return err
 	}
 	var resp struct {
 		K struct {
@@ -437,22 +583,24 @@ func (s *session) DelayTaskById(taskid string) error {
 		} `json:"0"`
 		Result byte `json:"result"`
 	}
-	json.Unmarshal(sub[1], &resp)
+	if err = c.Decode(0, &resp); err != nil {
+		return malformedResponse("task_delay", r, err)
+	}
 	log.Infof("%s: %s\n", taskid, resp.K.Llt)
 	return nil
 }
 
-func (s *session) FillBtList(t *Task) (*btList, error) {
+func (s *session) FillBtListContext(ctx context.Context, t *Task) (*BtList, error) {
 	if t == nil {
-		return nil, errTaskNotFound
+		return nil, ErrTaskNotFound
 	}
-	return s.FillBtListById(t.Id, t.Cid)
+	return s.FillBtListByIdContext(ctx, t.Id, t.Cid)
 }
 
-func (s *session) FillBtListById(taskid, infohash string) (*btList, error) {
+func (s *session) FillBtListByIdContext(ctx context.Context, taskid, infohash string) (*BtList, error) {
 	var pgsize = btPageSize
 retry:
-	m, err := s.fillBtList(taskid, infohash, 1, pgsize)
+	m, err := s.fillBtList(ctx, taskid, infohash, 1, pgsize)
 	if err == io.ErrUnexpectedEOF && pgsize == btPageSize {
 		pgsize = "100"
 		goto retry
@@ -460,129 +608,153 @@ retry:
 	if err != nil {
 		return nil, err
 	}
-	var list = *m
-	total, _ := strconv.Atoi(list.BtNum)
+	records := m.Record
+	total, _ := strconv.Atoi(m.BtNum)
 	size, _ := strconv.Atoi(pgsize)
 	pageNum := total/size + 1
-	next := 2
-	for next <= pageNum {
-		m, err = s.fillBtList(taskid, infohash, next, pgsize)
-		if err == nil {
-			if len(m.Record) > 0 {
-				list.Record = append(list.Record, m.Record...)
-			}
-			next++
-		} else {
-			log.Error("err in fillBtList()")
+	for next := 2; next <= pageNum; next++ {
+		p, err := s.fillBtList(ctx, taskid, infohash, next, pgsize)
+		if err != nil {
+			return nil, err
 		}
+		records = append(records, p.Record...)
 	}
-	return &list, nil
+	list := &BtList{Id: m.Id, InfoId: m.InfoId, Files: make([]BtFile, len(records))}
+	for i := range records {
+		list.Files[i] = records[i].file()
+	}
+	return list, nil
 }
 
-func (s *session) RawFillBtList(t *Task, page int) ([]byte, error) {
+func (s *session) RawFillBtListContext(ctx context.Context, t *Task, page int) ([]byte, error) {
 	if t == nil {
-		return nil, errTaskNotFound
+		return nil, ErrTaskNotFound
 	}
-	return s.RawFillBtListById(t.Id, t.Cid, page)
+	return s.RawFillBtListByIdContext(ctx, t.Id, t.Cid, page)
 }
 
-func (s *session) RawFillBtListById(taskid, infohash string, page int) ([]byte, error) {
+func (s *session) RawFillBtListByIdContext(ctx context.Context, taskid, infohash string, page int) ([]byte, error) {
 	var pgsize = btPageSize
 retry:
-	uri := fmt.Sprintf(fillbtlistURI, taskid, infohash, page, s.uid, "task", currentTimestamp())
-	log.Debugf("==> %s", uri)
-	req, err := http.NewRequest("GET", uri, nil)
+	uri := s.lixianURL(fillbtlistURI, taskid, infohash, page, s.userId(), "task", currentTimestamp())
+	req, err := newRequest("GET", uri, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("User-Agent", userAgent)
-	req.Header.Add("Accept-Encoding", "gzip, deflate")
 	req.AddCookie(&http.Cookie{Name: "pagenum", Value: pgsize})
-	resp, err := s.routine(req)
-	if err != nil {
-		return nil, err
-	}
-	log.Debug(resp.Status)
-	defer resp.Body.Close()
-	r, err := readBody(resp)
+	r, err := s.fetch(ctx, req, true)
 	if err == io.ErrUnexpectedEOF && pgsize == btPageSize {
 		pgsize = "100"
 		goto retry
 	}
-	return r, nil
+	return r, err
 }
 
 // supported uri schemes:
 // 'ed2k', 'http', 'https', 'ftp', 'bt', 'magnet', 'thunder', 'Flashget', 'qqdl'
-func (s *session) AddTask(req string) error {
-	ttype := taskTypeOrdinary
-	if strings.HasPrefix(req, "magnet:") || strings.Contains(req, "get_torrent?userid=") {
-		ttype = taskTypeMagnet
-	} else if strings.HasPrefix(req, "ed2k://") {
-		ttype = taskTypeEd2k
-	} else if strings.HasPrefix(req, "bt://") || strings.HasSuffix(req, ".torrent") {
-		ttype = taskTypeBt
-	} else if ok, _ := regexp.MatchString(`^[a-zA-Z0-9]{40,40}$`, req); ok {
-		ttype = taskTypeBt
-		req = "bt://" + req
-	} else if hasScheme, _ := regexp.Match(`://`, []byte(req)); !hasScheme {
-		ttype = taskTypeBt
-	}
+func (s *session) AddTaskContext(ctx context.Context, req string, opts ...AddOption) error {
+	ttype, req := linkType(req)
 	switch ttype {
-	case taskTypeOrdinary, taskTypeEd2k:
-		return s.addSimpleTask(req)
-	case taskTypeBt:
-		return s.addBtTask(req)
-	case taskTypeMagnet:
-		return s.addMagnetTask(req)
-	case taskTypeInvalid:
+	case linkOrdinary, linkEd2k:
+		return s.addSimpleTask(ctx, req, newAddOptions(opts))
+	case linkBt, linkMagnet:
+		p, err := s.previewBtTask(ctx, ttype, req)
+		if err != nil {
+			return err
+		}
+		p.apply(newAddOptions(opts))
+		_, err = s.submitBtTask(ctx, p)
+		return err
+	case linkInvalid:
 		fallthrough
 	default:
 	}
-	return errUnexpected
+	return ErrUnexpected
 }
 
-func (s *session) AddBatchTasks(urls []string, oids ...string) error {
-	// TODO: filter urls
-	v := url.Values{}
-	for i := 0; i < len(urls); i++ {
-		v.Add("cid[]", "")
-		v.Add("url[]", url.QueryEscape(urls[i]))
-	}
-	v.Add("class_id", "0")
-	if len(oids) > 0 {
-		var b bytes.Buffer
-		for i := 0; i < len(oids); i++ {
-			b.WriteString("0,")
-		}
-		v.Add("batch_old_taskid", strings.Join(oids, ","))
-		v.Add("batch_old_database", b.String())
-		v.Add("interfrom", "history")
-	} else {
-		v.Add("batch_old_taskid", "0,")
-		v.Add("batch_old_database", "0,")
-		v.Add("interfrom", "task")
+// PreviewBtTaskContext lists the files of the bt task AddTask would submit
+// for req, a magnet link, an infohash or a torrent file, marking those
+// selected by opts, without submitting anything.
+func (s *session) PreviewBtTaskContext(ctx context.Context, req string, opts ...AddOption) (*BtPreview, error) {
+	ttype, req := linkType(req)
+	if ttype != linkBt && ttype != linkMagnet {
+		return nil, ErrInvalidQuery
 	}
-	tm := currentTimestamp()
-	r, err := s.post(fmt.Sprintf(batchtaskcommitURI, tm, tm), v.Encode())
-	fmt.Printf("%s\n", r)
-	return err
+	p, err := s.previewBtTask(ctx, ttype, req)
+	if err != nil {
+		return nil, err
+	}
+	p.apply(newAddOptions(opts))
+	return p, nil
 }
 
-func (s *session) ProcessTaskDaemon(ch chan byte, callback TaskCallback) {
-	if len(s.cache.Tasks) == 0 {
-		s.GetIncompletedTasks()
+// AddTorrentContext submits the bt task for the torrent read from r, and
+// returns the id of the task and its infohash.
+func (s *session) AddTorrentContext(ctx context.Context, r io.Reader, opts ...AddOption) (string, string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", "", err
+	}
+	return s.AddTorrentBytesContext(ctx, b, opts...)
+}
+
+// AddTorrentBytesContext submits the bt task for the torrent b, and returns
+// the id of the task and its infohash. The torrent is checked locally before
+// being uploaded; ErrInvalidTorrent is returned if it cannot be decoded.
+func (s *session) AddTorrentBytesContext(ctx context.Context, b []byte, opts ...AddOption) (string, string, error) {
+	m, err := taipei.DecodeMetaInfo(b)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidTorrent, err)
+	}
+	filename := "upload.torrent"
+	if m.Info.Name != "" {
+		filename = m.Info.Name + ".torrent"
+	}
+	p, err := s.uploadTorrent(ctx, filename, b)
+	if err != nil {
+		return "", "", err
+	}
+	p.apply(newAddOptions(opts))
+	id, err := s.submitBtTask(ctx, p)
+	if err != nil {
+		return "", "", err
+	}
+	return id, p.InfoId, nil
+}
+
+// linkType tells the kind of the link given to AddTask, and returns it in
+// the form the server expects.
+func linkType(req string) (int, string) {
+	if strings.HasPrefix(req, "magnet:") || strings.Contains(req, "get_torrent?userid=") {
+		return linkMagnet, req
+	} else if strings.HasPrefix(req, "ed2k://") {
+		return linkEd2k, req
+	} else if strings.HasPrefix(req, "bt://") || strings.HasSuffix(req, ".torrent") {
+		return linkBt, req
+	} else if ok, _ := regexp.MatchString(`^[a-zA-Z0-9]{40,40}$`, req); ok {
+		return linkBt, "bt://" + req
+	} else if hasScheme, _ := regexp.Match(`://`, []byte(req)); !hasScheme {
+		return linkBt, req
+	}
+	return linkOrdinary, req
+}
+
+func (s *session) ProcessTaskDaemonContext(ctx context.Context, ch chan byte, callback TaskCallback) {
+	if s.cache.size() == 0 {
+		s.GetIncompletedTasksContext(ctx)
 	}
 	go func() {
 		for {
 			select {
+			case <-ctx.Done():
+				return
 			case <-ch:
-				err := s.processTask(callback)
+				err := s.processTask(ctx, callback)
 				if err != nil {
 					log.Errorf("error in ProcessTask(): %v", err)
 				}
 			case <-time.After(60 * time.Second):
-				err := s.processTask(callback)
+				err := s.processTask(ctx, callback)
 				if err != nil {
 					log.Errorf("error in ProcessTask(): %v", err)
 					time.Sleep(5 * time.Second)
@@ -593,63 +765,56 @@ func (s *session) ProcessTaskDaemon(ch chan byte, callback TaskCallback) {
 	}()
 }
 
-func (s *session) ProcessTask(callback TaskCallback) error {
-	return s.processTask(callback)
+func (s *session) ProcessTaskContext(ctx context.Context, callback TaskCallback) error {
+	return s.processTask(ctx, callback)
 }
 
-func (s *session) GetTorrentByHash(hash string) ([]byte, error) {
-	r, err := s.get(fmt.Sprintf(gettorrentURI, s.uid, strings.ToUpper(hash)))
+func (s *session) GetTorrentByHashContext(ctx context.Context, hash string) ([]byte, error) {
+	r, err := s.get(ctx, s.lixianURL(gettorrentURI, s.userId(), strings.ToUpper(hash)))
 	if err != nil {
 		return nil, err
 	}
-	exp := regexp.MustCompile(`alert\('(.*)'\)`)
-	sub := exp.FindSubmatch(r)
-	if sub != nil {
-		log.Infof("%s\n", sub[1])
-		return nil, errInvalidResponse
+	if msg, ok := findAlert(r); ok {
+		return nil, newAPIError("get_torrent", "", msg, r, ErrInvalidResponse)
 	}
 	return r, nil
 }
 
-func (s *session) GetTorrentFileByHash(hash, file string) error {
+func (s *session) GetTorrentFileByHashContext(ctx context.Context, hash, file string) error {
 	if stat, err := os.Stat(file); err == nil || stat != nil {
 		return os.ErrExist
 	}
-	r, err := s.GetTorrentByHash(hash)
+	r, err := s.GetTorrentByHashContext(ctx, hash)
 	if err != nil {
 		return err
 	}
 	return ioutil.WriteFile(file, r, 0644)
 }
 
-func (s *session) PauseTask(t *Task) error {
+func (s *session) PauseTaskContext(ctx context.Context, t *Task) error {
 	tids := t.Id + ","
-	uri := fmt.Sprintf(taskpauseURI, tids, s.uid, currentTimestamp())
-	r, err := s.get(uri)
+	uri := s.lixianURL(taskpauseURI, tids, s.userId(), currentTimestamp())
+	r, err := s.get(ctx, uri)
 	if err != nil {
 		return err
 	}
-	if bytes.Compare(r, []byte("pause_task_resp()")) != 0 {
-		return errInvalidResponse
-	}
-	return nil
+	_, err = parseCall("task_pause", r, "pause_task_resp")
+	return err
 }
 
-func (s *session) PauseTasks(ids []string) error {
+func (s *session) PauseTasksContext(ctx context.Context, ids []string) error {
 	tids := strings.Join(ids, ",")
 	tids += ","
-	r, err := s.get(fmt.Sprintf(taskpauseURI, tids, s.uid, currentTimestamp()))
+	r, err := s.get(ctx, s.lixianURL(taskpauseURI, tids, s.userId(), currentTimestamp()))
 	if err != nil {
 		return err
 	}
-	if bytes.Compare(r, []byte("pause_task_resp()")) != 0 {
-		return errInvalidResponse
-	}
-	return nil
+	_, err = parseCall("task_pause", r, "pause_task_resp")
+	return err
 }
 
-func (s *session) DelayAllTasks() error {
-	r, err := s.get(delayonceURI)
+func (s *session) DelayAllTasksContext(ctx context.Context) error {
+	r, err := s.get(ctx, s.lixianURL(delayonceURI))
 	if err != nil {
 		return err
 	}
@@ -657,94 +822,109 @@ func (s *session) DelayAllTasks() error {
 	return nil
 }
 
-func (s *session) ReAddTask(t *Task) error {
+func (s *session) ReAddTaskContext(ctx context.Context, t *Task) error {
 	if t == nil {
-		return errTaskNotFound
+		return ErrTaskNotFound
 	}
 	if t.normal() {
-		return errTaskAlreadyQueued
+		return ErrTaskAlreadyQueued
 	}
 	if t.purged() {
-		return s.addSimpleTask(t.URL)
+		return s.addSimpleTask(ctx, t.URL, newAddOptions(nil))
 	}
-	return s.addSimpleTask(t.URL, t.Id)
+	return s.addSimpleTask(ctx, t.URL, newAddOptions(nil), t.Id)
 }
 
-func (s *session) ReAddTasks(ts map[string]*Task) {
+// ReAddTasksContext re-adds the expired and deleted tasks of ts, and returns
+// the outcome for each task of ts; the others fail with ErrTaskAlreadyQueued
+// or ErrTaskAlreadyPurged.
+func (s *session) ReAddTasksContext(ctx context.Context, ts map[string]*Task) []BatchResult {
+	rs := make([]BatchResult, 0, len(ts))
 	nbt := make([]*Task, 0, len(ts))
 	bt := make([]*Task, 0, len(ts))
 	for i := range ts {
-		if ts[i].expired() || ts[i].deleted() {
+		switch {
+		case ts[i].expired() || ts[i].deleted():
 			if ts[i].IsBt() {
 				bt = append(bt, ts[i])
 			} else {
 				nbt = append(nbt, ts[i])
 			}
+		case ts[i].purged():
+			rs = append(rs, BatchResult{URL: ts[i].URL, Err: ErrTaskAlreadyPurged})
+		default:
+			rs = append(rs, BatchResult{URL: ts[i].URL, Err: ErrTaskAlreadyQueued})
 		}
 	}
 	if len(nbt) == 1 {
-		if err := s.ReAddTask(nbt[0]); err != nil {
-			log.Error(err.Error())
-		}
+		rs = append(rs, BatchResult{URL: nbt[0].URL, Err: s.ReAddTaskContext(ctx, nbt[0])})
 	} else if len(nbt) > 1 {
 		urls, ids := extractTasks(nbt)
-		if err := s.AddBatchTasks(urls, ids...); err != nil {
-			log.Error(err.Error())
+		r, err := s.AddBatchTasksContext(ctx, urls, ids...)
+		if err != nil {
+			for i := range urls {
+				rs = append(rs, BatchResult{URL: urls[i], Err: err})
+			}
 		}
+		rs = append(rs, r...)
 	}
 	for i := range bt {
-		if err := s.addMagnetTask(fmt.Sprintf(gettorrentURI, s.uid, bt[i].Cid), bt[i].Id); err != nil {
-			log.Error(err.Error())
+		r := BatchResult{URL: bt[i].URL}
+		p, err := s.queryMagnet(ctx, s.lixianURL(gettorrentURI, s.userId(), bt[i].Cid), bt[i].Id)
+		if err == nil {
+			r.TaskId, err = s.submitBtTask(ctx, p)
 		}
+		r.Err = err
+		rs = append(rs, r)
 	}
+	return rs
 }
 
-func (s *session) RenameTask(t *Task, newname string) error {
+func (s *session) RenameTaskContext(ctx context.Context, t *Task, newname string) error {
 	if t == nil {
-		return errTaskNotFound
+		return ErrTaskNotFound
 	}
-	return s.RenameTaskById(t.Id, newname)
+	return s.RenameTaskByIdContext(ctx, t.Id, newname)
 }
 
-func (s *session) RenameTaskById(taskid, newname string) error {
+func (s *session) RenameTaskByIdContext(ctx context.Context, taskid, newname string) error {
 	t := s.cache.getTaskbyId(taskid)
 	if t == nil {
-		return errTaskNotFound
+		return ErrTaskNotFound
 	}
-	return s.renameTask(taskid, newname, t.TaskType)
+	return s.renameTask(ctx, taskid, newname, t.IsBt())
 }
 
-func (s *session) ResumeTask(t *Task) error {
+func (s *session) ResumeTaskContext(ctx context.Context, t *Task) error {
 	if t == nil {
-		return errTaskNotFound
+		return ErrTaskNotFound
 	}
-	return s.ResumeTaskById(t.Id)
+	return s.ResumeTaskByIdContext(ctx, t.Id)
 }
 
-func (s *session) ResumeTaskById(taskid string) error {
+func (s *session) ResumeTaskByIdContext(ctx context.Context, taskid string) error {
 	t := s.cache.getTaskbyId(taskid)
 	if t == nil {
-		return errTaskNotFound
+		return ErrTaskNotFound
 	}
 	if t.expired() {
-		return errTaskNoRedownCap
+		return ErrTaskNoRedownCap
 	}
-	status := t.DownloadStatus
-	if status != "5" && status != "3" {
-		return errTaskNoRedownCap // only valid for `pending` and `failed` tasks
+	if !t.pending() && !t.failed() {
+		return ErrTaskNoRedownCap // only valid for `pending` and `failed` tasks
 	}
 	form := make([]string, 0, 3)
 	v := url.Values{}
 	v.Add("id[]", t.Id)
 	v.Add("url[]", t.URL)
 	v.Add("cid[]", t.Cid)
-	v.Add("download_status[]", status)
+	v.Add("download_status[]", t.DownloadStatus)
 	v.Add("taskname[]", t.TaskName)
 	form = append(form, v.Encode())
 	form = append(form, "type=1")
 	form = append(form, "interfrom=task")
-	uri := fmt.Sprintf(redownloadURI, currentTimestamp())
-	r, err := s.post(uri, strings.Join(form, "&"))
+	uri := s.lixianURL(redownloadURI, currentTimestamp())
+	r, err := s.commitForm(ctx, uri, strings.Join(form, "&"))
 	if err != nil {
 		return err
 	}
@@ -752,48 +932,48 @@ func (s *session) ResumeTaskById(taskid string) error {
 	return nil
 }
 
-func (s *session) DeleteTask(t *Task) error {
+func (s *session) DeleteTaskContext(ctx context.Context, t *Task) error {
 	if t == nil {
-		return errTaskNotFound
+		return ErrTaskNotFound
 	}
-	return s.DeleteTaskById(t.Id)
+	return s.DeleteTaskByIdContext(ctx, t.Id)
 }
 
-func (s *session) DeleteTaskById(taskid string) error {
+func (s *session) DeleteTaskByIdContext(ctx context.Context, taskid string) error {
 	t := s.cache.getTaskbyId(taskid)
 	if t == nil {
-		return errTaskNotFound
+		return ErrTaskNotFound
 	}
-	return s.removeTask(t, 0)
+	return s.removeTask(ctx, t, 0)
 }
 
-func (s *session) PurgeTask(t *Task) error {
+func (s *session) PurgeTaskContext(ctx context.Context, t *Task) error {
 	if t == nil {
-		return errTaskNotFound
+		return ErrTaskNotFound
 	}
-	return s.PurgeTaskById(t.Id)
+	return s.PurgeTaskByIdContext(ctx, t.Id)
 }
 
-func (s *session) PurgeTaskById(taskid string) error {
+func (s *session) PurgeTaskByIdContext(ctx context.Context, taskid string) error {
 	t := s.cache.getTaskbyId(taskid)
 	if t == nil {
-		return errTaskNotFound
+		return ErrTaskNotFound
 	}
 	if t.deleted() {
-		return s.removeTask(t, 1)
+		return s.removeTask(ctx, t, 1)
 	}
-	if err := s.removeTask(t, 0); err != nil {
+	if err := s.removeTask(ctx, t, 0); err != nil {
 		return err
 	}
-	return s.removeTask(t, 1)
+	return s.removeTask(ctx, t, 1)
 }
 
-func (s *session) VerifyTask(t *Task, path string) bool {
+func (s *session) VerifyTaskContext(ctx context.Context, t *Task, path string) bool {
 	if t.IsBt() {
 		fmt.Println("Verifying [BT]", path)
 		var b []byte
 		var err error
-		if b, err = s.GetTorrentByHash(t.Cid); err != nil {
+		if b, err = s.GetTorrentByHashContext(ctx, t.Cid); err != nil {
 			fmt.Println(err)
 			return false
 		}
@@ -834,6 +1014,18 @@ func (s *session) InvalidateCacheAll()                             { s.cache.Inv
 
 // ---- private ----
 
+func (s *session) userId() string {
+	s.lock()
+	defer s.unlock()
+	return s.uid
+}
+
+func (s *session) setUserId(uid string) {
+	s.lock()
+	s.uid = uid
+	s.unlock()
+}
+
 func (s *session) lock() {
 	s.mutex.Lock()
 }
@@ -842,71 +1034,165 @@ func (s *session) unlock() {
 	s.mutex.Unlock()
 }
 
-func (s *session) routine(req *http.Request) (*http.Response, error) {
-	timeout := false
-retry:
-	s.lock()
-	timer := time.AfterFunc(s.timeout, func() {
-		s.Client.Transport.(*http.Transport).CancelRequest(req)
-		timeout = true
-	})
-	resp, err := s.Do(req)
-	if timer != nil {
-		timer.Stop()
+// routine sends req bound to ctx once the throttle admits it; s.timeout limits
+// the wait for response headers, while ctx also covers reading the body,
+// which is released on Body.Close().
+func (s *session) routine(ctx context.Context, req *http.Request) (*http.Response, error) {
+	release, err := s.throttle.acquire(ctx, req.URL.Host)
+	if err != nil {
+		return nil, err
 	}
+	rctx, cancel := context.WithCancel(ctx)
+	timer := time.AfterFunc(s.timeout, cancel)
+	resp, err := s.Do(req.WithContext(rctx))
+	timeout := !timer.Stop()
+	if err != nil {
+		cancel()
+		release()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if timeout {
+			return nil, ErrTimeout
+		}
+		return nil, err
+	}
+	resp.Body = &releaseReadCloser{ReadCloser: resp.Body, release: func() {
+		cancel()
+		release()
+	}}
+	return resp, nil
+}
+
+// fetch sends req and reads the response body. If the reply shows the session
+// has expired, it logs in again and replays req once, unless ctx is marked
+// withoutRenewal, in which case ErrSessionExpired is returned.
+func (s *session) fetch(ctx context.Context, req *http.Request, idempotent bool) ([]byte, error) {
+	s.lock()
+	epoch := s.epoch
 	s.unlock()
-	if err == io.EOF && !timeout {
-		goto retry
+	r, err := s.fetchRetry(ctx, req, idempotent)
+	if err != nil || !sessionExpiredPattern.Match(r) {
+		return r, err
+	}
+	if !renewable(ctx) {
+		return nil, ErrSessionExpired
+	}
+	if err = s.renew(ctx, epoch); err != nil {
+		return nil, err
+	}
+	if err = rewind(req); err != nil {
+		return nil, err
 	}
-	if timeout {
-		err = errTimeout
+	if r, err = s.fetchRetry(ctx, req, idempotent); err == nil && sessionExpiredPattern.Match(r) {
+		return nil, ErrSessionExpired
 	}
-	return resp, err
+	return r, err
 }
 
-func (s *session) get(dest string) ([]byte, error) {
-	log.Debugf("==> %s", dest)
-	req, err := http.NewRequest("GET", dest, nil)
-	if err != nil {
-		return nil, err
+// fetchRetry sends req and reads the response body, retrying as s.retry allows;
+// unless idempotent, req is only sent again if it never reached the server.
+func (s *session) fetchRetry(ctx context.Context, req *http.Request, idempotent bool) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			if err := rewind(req); err != nil {
+				return nil, err
+			}
+		}
+		r, resp, err := s.fetchOnce(ctx, req)
+		if err == nil {
+			return r, nil
+		}
+		if ctx.Err() != nil || attempt >= s.retry.MaxAttempts ||
+			!s.retry.retryable(err, resp) || (!idempotent && !notSent(err)) {
+			return nil, err
+		}
+		log.Debugf("retry %s (%d/%d): %v", req.URL, attempt+1, s.retry.MaxAttempts, err)
+		if err = sleepContext(ctx, s.retry.backoff(attempt)); err != nil {
+			return nil, err
+		}
 	}
-	req.Header.Add("User-Agent", userAgent)
-	req.Header.Add("Accept-Encoding", "gzip, deflate")
-	resp, err := s.routine(req)
+}
+
+func (s *session) fetchOnce(ctx context.Context, req *http.Request) ([]byte, *http.Response, error) {
+	resp, err := s.routine(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 	log.Debug(resp.Status)
 	if resp.StatusCode/100 > 3 {
-		return nil, errors.New(resp.Status)
+		return nil, resp, errors.New(resp.Status)
 	}
-	return readBody(resp)
+	r, err := readBody(resp)
+	if err != nil {
+		return nil, resp, err
+	}
+	return r, resp, nil
+}
+
+// rewind resets the body of req so it can be sent again.
+func rewind(req *http.Request) error {
+	if req.GetBody == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
 }
 
-func (s *session) post(dest string, data string) ([]byte, error) {
+func newRequest(method, dest string, body io.Reader) (*http.Request, error) {
 	log.Debugf("==> %s", dest)
-	req, err := http.NewRequest("POST", dest, strings.NewReader(data))
+	req, err := http.NewRequest(method, dest, body)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Add("User-Agent", userAgent)
 	req.Header.Add("Accept-Encoding", "gzip, deflate")
-	resp, err := s.routine(req)
+	return req, nil
+}
+
+func (s *session) send(ctx context.Context, dest string, data *string, idempotent bool) ([]byte, error) {
+	var req *http.Request
+	var err error
+	if data == nil {
+		req, err = newRequest("GET", dest, nil)
+	} else if req, err = newRequest("POST", dest, strings.NewReader(*data)); err == nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	log.Debug(resp.Status)
-	if resp.StatusCode/100 > 3 {
-		return nil, errors.New(resp.Status)
-	}
-	return readBody(resp)
+	return s.fetch(ctx, req, idempotent)
+}
+
+func (s *session) get(ctx context.Context, dest string) ([]byte, error) {
+	return s.send(ctx, dest, nil, true)
+}
+
+func (s *session) post(ctx context.Context, dest string, data string) ([]byte, error) {
+	return s.send(ctx, dest, &data, true)
+}
+
+// commit and commitForm are get and post for requests that submit tasks,
+// which must not be replayed once they may have reached the server.
+func (s *session) commit(ctx context.Context, dest string) ([]byte, error) {
+	return s.send(ctx, dest, nil, false)
+}
+
+func (s *session) commitForm(ctx context.Context, dest string, data string) ([]byte, error) {
+	return s.send(ctx, dest, &data, false)
+}
+
+func (s *session) lixianURL(format string, a ...interface{}) string {
+	return s.lixianURI + fmt.Sprintf(format, a...)
 }
 
 func (s *session) getCookie(name string) string {
-	cks := s.Client.Jar.Cookies(urlXunleiCom)
+	cks := s.jar.Cookies(s.cookieURLs[0])
 	for i := range cks {
 		if cks[i].Name == name {
 			return cks[i].Value
@@ -915,16 +1201,20 @@ func (s *session) getCookie(name string) string {
 	return ""
 }
 
-func (s *session) renameTask(taskid, newname string, tasktype byte) error {
+func (s *session) renameTask(ctx context.Context, taskid, newname string, bt bool) error {
 	v := url.Values{}
 	v.Add("taskid", taskid)
-	if tasktype == 0 {
+	if bt {
 		v.Add("bt", "1")
 	} else {
 		v.Add("bt", "0")
 	}
 	v.Add("filename", newname)
-	r, err := s.get(renameURI + v.Encode())
+	r, err := s.get(ctx, s.lixianURL(renameURI)+v.Encode())
+	if err != nil {
+		return err
+	}
+	c, err := parseCall("rename", r, "")
 	if err != nil {
 		return err
 	}
@@ -933,61 +1223,50 @@ func (s *session) renameTask(taskid, newname string, tasktype byte) error {
 		TaskId   int    `json:"taskid"`
 		FileName string `json:"filename"`
 	}
-	json.Unmarshal(r[1:len(r)-1], &resp)
+	if err = c.Decode(0, &resp); err != nil {
+		return malformedResponse("rename", r, err)
+	}
 	if resp.Result != 0 {
-		return fmt.Errorf("error in rename task: %d", resp.Result)
+		return newAPIError("rename", strconv.Itoa(resp.Result), "", r, ErrUnexpected)
 	}
-	log.Infof("%s => %s", resp.TaskId, resp.FileName)
+	log.Infof("%d => %s", resp.TaskId, resp.FileName)
 	return nil
 }
 
-func (s *session) readExpired() ([]byte, error) {
-	uri := fmt.Sprintf(expireHomeURI, s.uid)
-	log.Debugf("==> %s", uri)
-	req, err := http.NewRequest("GET", uri, nil)
+func (s *session) readExpired(ctx context.Context, page int) ([]byte, error) {
+	var uri string
+	if page > 0 {
+		uri = s.lixianURL(expirePageURI, s.userId(), page)
+	} else {
+		uri = s.lixianURL(expireHomeURI, s.userId())
+	}
+	req, err := newRequest("GET", uri, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("User-Agent", userAgent)
-	req.Header.Add("Accept-Encoding", "gzip, deflate")
 	req.AddCookie(&http.Cookie{Name: "lx_nf_all", Value: url.QueryEscape(expiredCk)})
 	req.AddCookie(&http.Cookie{Name: "pagenum", Value: pageSize})
-	resp, err := s.routine(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	log.Debug(resp.Status)
-	return readBody(resp)
+	return s.fetch(ctx, req, true)
 }
 
-func (s *session) readHistory(page int) ([]byte, error) {
+func (s *session) readHistory(ctx context.Context, page int) ([]byte, error) {
 	var uri string
 	if page > 0 {
-		uri = fmt.Sprintf(historyPageURI, s.uid, page)
+		uri = s.lixianURL(historyPageURI, s.userId(), page)
 	} else {
-		uri = fmt.Sprintf(historyHomeURI, s.uid)
+		uri = s.lixianURL(historyHomeURI, s.userId())
 	}
 
-	log.Debugf("==> %s", uri)
-	req, err := http.NewRequest("GET", uri, nil)
+	req, err := newRequest("GET", uri, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("User-Agent", userAgent)
-	req.Header.Add("Accept-Encoding", "gzip, deflate")
 	req.AddCookie(&http.Cookie{Name: "lx_nf_all", Value: url.QueryEscape(deletedCk)})
 	req.AddCookie(&http.Cookie{Name: "pagenum", Value: pageSize})
-	resp, err := s.routine(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	log.Debug(resp.Status)
-	return readBody(resp)
+	return s.fetch(ctx, req, true)
 }
 
-func (s *session) redownload(tasks []*Task) error {
+func (s *session) redownload(ctx context.Context, tasks []*Task) error {
 	form := make([]string, 0, len(tasks)+2)
 	for i := range tasks {
 		if tasks[i].expired() || !tasks[i].failed() || !tasks[i].pending() {
@@ -1002,11 +1281,11 @@ func (s *session) redownload(tasks []*Task) error {
 		form = append(form, v.Encode())
 	}
 	if len(form) == 0 {
-		return errTaskAlreadyQueued
+		return ErrTaskAlreadyQueued
 	}
 	form = append(form, "type=1")
 	form = append(form, "interfrom=task")
-	r, err := s.post(fmt.Sprintf(redownloadURI, currentTimestamp()), strings.Join(form, "&"))
+	r, err := s.commitForm(ctx, s.lixianURL(redownloadURI, currentTimestamp()), strings.Join(form, "&"))
 	if err != nil {
 		return err
 	}
@@ -1014,48 +1293,35 @@ func (s *session) redownload(tasks []*Task) error {
 	return nil
 }
 
-func (s *session) fillBtList(taskid, infohash string, page int, pgsize string) (*btList, error) {
-	uri := fmt.Sprintf(fillbtlistURI, taskid, infohash, page, s.uid, "task", currentTimestamp())
-	log.Debugf("==> %s", uri)
-	req, err := http.NewRequest("GET", uri, nil)
+func (s *session) fillBtList(ctx context.Context, taskid, infohash string, page int, pgsize string) (*btList, error) {
+	uri := s.lixianURL(fillbtlistURI, taskid, infohash, page, s.userId(), "task", currentTimestamp())
+	req, err := newRequest("GET", uri, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("User-Agent", userAgent)
-	req.Header.Add("Accept-Encoding", "gzip, deflate")
 	req.AddCookie(&http.Cookie{Name: "pagenum", Value: pgsize})
-	resp, err := s.routine(req)
+	r, err := s.fetch(ctx, req, true)
 	if err != nil {
 		return nil, err
 	}
-	log.Debug(resp.Status)
-	r, err := readBody(resp)
-	resp.Body.Close()
+	if msg, ok := findAlert(r); ok {
+		return nil, newAPIError("fill_bt_list", "", msg, r, ErrUnexpected)
+	}
+	c, err := parseCall("fill_bt_list", r, "fill_bt_list")
 	if err != nil {
 		return nil, err
 	}
-	exp := regexp.MustCompile(`fill_bt_list\({"Result":(.*)}\)`)
-	sub := exp.FindSubmatch(r)
-	if sub == nil {
-		exp = regexp.MustCompile(`alert\('(.*)'\);.*`)
-		sub = exp.FindSubmatch(r)
-		if sub != nil {
-			return nil, errors.New(string(sub[1]))
-		}
-		log.Debugf("fill_bt_list result: %s", r)
-		return nil, errInvalidResponse
+	var resp struct {
+		Result *btList
 	}
-	var btlist btList
-	json.Unmarshal(sub[1], &btlist)
-	exp = regexp.MustCompile(`\\`)
-	for i := range btlist.Record {
-		btlist.Record[i].FileName = exp.ReplaceAllLiteralString(btlist.Record[i].FileName, `/`)
-		btlist.Record[i].FileName = unescapeName(btlist.Record[i].FileName)
+	if err = c.Decode(0, &resp); err != nil || resp.Result == nil {
+		log.Debugf("fill_bt_list result: %s", r)
+		return nil, invalidResponse("fill_bt_list", r)
 	}
-	return &btlist, nil
+	return resp.Result, nil
 }
 
-func (s *session) addSimpleTask(uri string, oid ...string) error {
+func (s *session) addSimpleTask(ctx context.Context, uri string, o *addOptions, oid ...string) error {
 	var from string
 	if len(oid) > 0 {
 		from = "history"
@@ -1064,24 +1330,27 @@ func (s *session) addSimpleTask(uri string, oid ...string) error {
 	}
 	exp := regexp.MustCompile(`%2C|,`)
 	uri = exp.ReplaceAllLiteralString(uri, `.`)
-	dest := fmt.Sprintf(taskcheckURI, url.QueryEscape(uri), from, currentRandom(), currentTimestamp())
-	r, err := s.get(dest)
+	dest := s.lixianURL(taskcheckURI, url.QueryEscape(uri), from, currentRandom(), currentTimestamp())
+	r, err := s.get(ctx, dest)
 	if err == nil {
 		taskPre, err := getTaskPre(r)
+		if errors.Is(err, ErrNeedBean) && o.spends(uri, taskPre) {
+			err = nil
+		}
 		if err != nil {
 			return err
 		}
 		var taskType string
 		if strings.HasPrefix(uri, "ed2k://") {
-			taskType = strconv.Itoa(taskTypeEd2k)
+			taskType = strconv.Itoa(linkEd2k)
 		} else {
-			taskType = strconv.Itoa(taskTypeOrdinary)
+			taskType = strconv.Itoa(linkOrdinary)
 			// strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "ftp://") || strings.HasPrefix(uri, "https://")
 			// return errors.New("invalid protocol scheme")
 		}
 		v := url.Values{}
 		v.Add("callback", "ret_task")
-		v.Add("uid", s.uid)
+		v.Add("uid", s.userId())
 		v.Add("cid", taskPre.Cid)
 		v.Add("gcid", taskPre.GCid)
 		v.Add("size", taskPre.SizeCost)
@@ -1090,6 +1359,9 @@ func (s *session) addSimpleTask(uri string, oid ...string) error {
 		v.Add("t", taskPre.FileName)
 		v.Add("url", uri)
 		v.Add("type", taskType)
+		if o.class > 0 {
+			v.Add("class_id", strconv.Itoa(o.class))
+		}
 		if len(oid) > 0 {
 			v.Add("o_taskid", oid[0])
 			v.Add("o_page", "history")
@@ -1097,222 +1369,192 @@ func (s *session) addSimpleTask(uri string, oid ...string) error {
 			v.Add("o_page", "task")
 			v.Add("o_taskid", "0")
 		}
-		dest = taskcommitURI + v.Encode()
-		if r, err = s.get(dest); err != nil {
+		dest = s.lixianURL(taskcommitURI) + v.Encode()
+		if r, err = s.commit(ctx, dest); err != nil {
 			return err
 		}
-		if ok, _ := regexp.Match(`ret_task\(.*\)`, r); ok {
-			return nil
-		}
-		return errInvalidResponse
+		_, err = parseCall("task_commit", r, "ret_task")
+		return err
 	}
 	return err
 }
 
-func (s *session) addBtTask(uri string) error {
-	if strings.HasPrefix(uri, "bt://") {
-		return s.addMagnetTask(fmt.Sprintf(gettorrentURI, s.uid, uri[5:]))
+// previewBtTask lists the files of the bt task for req, as classified by
+// linkType, all of them selected.
+func (s *session) previewBtTask(ctx context.Context, ttype int, req string) (*BtPreview, error) {
+	if ttype == linkMagnet {
+		return s.queryMagnet(ctx, req)
+	}
+	if strings.HasPrefix(req, "bt://") {
+		return s.queryMagnet(ctx, s.lixianURL(gettorrentURI, s.userId(), req[5:]))
 	}
-	return s.addTorrentTask(uri)
+	b, err := ioutil.ReadFile(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = taipei.DecodeMetaInfo(b); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidTorrent, req, err)
+	}
+	return s.uploadTorrent(ctx, filepath.Base(req), b)
 }
 
-func (s *session) addMagnetTask(link string, oid ...string) error {
-	r, err := s.get(fmt.Sprintf(urlqueryURI, url.QueryEscape(link), currentRandom()))
+// submitBtTask submits the files of p that are selected, and returns the id
+// of the task.
+func (s *session) submitBtTask(ctx context.Context, p *BtPreview) (string, error) {
+	v, err := p.commitForm()
 	if err != nil {
-		return err
+		return "", err
 	}
-	exp := regexp.MustCompile(`queryUrl\((1,.*)\)`)
-	sub := exp.FindSubmatch(r)
-	if sub == nil {
-		if ok, _ := regexp.Match(`queryUrl\(-1,'[0-9A-Za-z]{40,40}'.*`, r); ok {
-			return errBtTaskExisted
-		}
+	return s.commitBtTask(ctx, v)
+}
+
+// queryMagnet lists the files of the torrent link refers to, a magnet link
+// or a get_torrent URL. If oid is given, the task re-adds that deleted task.
+func (s *session) queryMagnet(ctx context.Context, link string, oid ...string) (*BtPreview, error) {
+	r, err := s.get(ctx, s.lixianURL(urlqueryURI, url.QueryEscape(link), currentRandom()))
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseCall("url_query", r, "queryUrl")
+	if err != nil {
+		return nil, err
+	}
+	switch code, _ := c.Int(0); code {
+	case 1:
+	case -1:
+		return nil, newAPIError("url_query", "-1", "", r, ErrTaskExisted)
+	default:
 		log.Debugf("bt query response: %s", r)
-		return errInvalidResponse
+		return nil, invalidResponse("url_query", r)
 	}
-	if resp := parseBtQueryResponse(sub[1]); resp != nil {
-		log.Debugf("parsed bt query response: %v", resp)
-		v := url.Values{}
-		v.Add("uid", s.uid)
-		v.Add("btname", resp.Name)
-		v.Add("cid", resp.InfoId)
-		v.Add("tsize", resp.Size)
-		findex := strings.Join(resp.Index, "_")
-		size := strings.Join(resp.Sizes, "_")
-		v.Add("findex", findex)
-		v.Add("size", size)
-		if len(oid) > 0 {
-			v.Add("from", "history")
-			v.Add("o_taskid", oid[0])
-			v.Add("o_page", "history")
-		} else {
-			v.Add("from", "task")
-		}
-		retryTimes := 10
-	retry:
-		log.Debugf("submit bt: %s", v.Encode())
-		r, err = s.post(fmt.Sprintf(bttaskcommitURI, currentTimestamp()), v.Encode())
-		exp = regexp.MustCompile(`jsonp.*\((\{.*\})\)`)
-		log.Debugf("bt submission response: %s", r)
-		sub = exp.FindSubmatch(r)
-		if sub == nil {
-			return errInvalidResponse
+	resp, err := parseBtQueryResponse(c)
+	if err != nil {
+		return nil, malformedResponse("url_query", r, err)
+	}
+	log.Debugf("parsed bt query response: %v", resp)
+	n := len(resp.Index)
+	if len(resp.Files) != n || len(resp.Sizes) != n {
+		return nil, malformedResponse("url_query", r, fmt.Errorf("%d files, %d sizes for %d indexes", len(resp.Files), len(resp.Sizes), n))
+	}
+	p := &BtPreview{InfoId: resp.InfoId, Name: unescapeName(resp.Name), Files: make([]BtEntry, n)}
+	p.Size, _ = strconv.ParseInt(resp.Size, 10, 64)
+	for i := range resp.Index {
+		var ext, picked string
+		if i < len(resp.Ext) {
+			ext = resp.Ext[i]
 		}
-		var submresp btSumbissionResponse
-		if err = json.Unmarshal(sub[1], &submresp); err != nil {
-			return err
-		}
-		switch submresp.Progress {
-		case 1:
-			return nil
-		case 2:
-			if submresp.ErrorMessage != nil {
-				return errors.New(submresp.Message)
-			}
-			return errTaskSubmissionFailed
-		case -11, -12:
-			if retryTimes <= 0 {
-				return errImageVerification
-			}
-			var w io.WriterTo
-			w, err = s.getVerifyImage()
-			os.Stdout.WriteString("\n")
-			w.WriteTo(os.Stdout)
-			os.Stdout.WriteString("input verify_code: ")
-			reader := bufio.NewReader(os.Stdin)
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				return err
-			}
-			if len(line) > 4 {
-				line = line[:4]
-			}
-			v.Set("verify_code", line)
-			retryTimes--
-			goto retry
-		default:
-			return errUnexpected
+		if i < len(resp.Picked) {
+			picked = resp.Picked[i]
 		}
+		p.Files[i] = newBtEntry(resp.Index[i], resp.Files[i], ext, resp.Sizes[i], picked == "1")
+	}
+	p.form = url.Values{}
+	p.form.Add("uid", s.userId())
+	p.form.Add("btname", resp.Name)
+	p.form.Add("cid", resp.InfoId)
+	p.form.Add("tsize", resp.Size)
+	if len(oid) > 0 {
+		p.form.Add("from", "history")
+		p.form.Add("o_taskid", oid[0])
+		p.form.Add("o_page", "history")
+	} else {
+		p.form.Add("from", "task")
 	}
-	return errInvalidResponse
+	return p, nil
 }
 
-func (s *session) addTorrentTask(filename string) (err error) {
-	var file *os.File
-	if file, err = os.Open(filename); err != nil {
-		return
-	}
-	defer file.Close()
-	// if _, err = taipei.GetMetaInfo(filename); err != nil {
-	//  return
-	// }
+// uploadTorrent uploads the torrent b, as filename, and lists its files.
+func (s *session) uploadTorrent(ctx context.Context, filename string, b []byte) (p *BtPreview, err error) {
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
 	var part io.Writer
 	if part, err = writer.CreateFormFile("filepath", filename); err != nil {
 		return
 	}
-	io.Copy(part, file)
+	part.Write(b)
 	writer.WriteField("random", currentRandom())
 	writer.WriteField("interfrom", "task")
+	writer.Close()
 
-	dest := torrentuploadURI
-	log.Debugf("==> %s", dest)
-	req, err := http.NewRequest("POST", dest, bytes.NewReader(body.Bytes()))
+	req, err := newRequest("POST", s.lixianURL(torrentuploadURI), bytes.NewReader(body.Bytes()))
 	if err != nil {
 		return
 	}
 	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Add("User-Agent", userAgent)
-	req.Header.Add("Accept-Encoding", "gzip, deflate")
-	resp, err := s.routine(req)
+	r, err := s.fetch(ctx, req, true)
 	if err != nil {
 		return
 	}
-	log.Debug(resp.Status)
-	r, err := readBody(resp)
-	resp.Body.Close()
-	exp := regexp.MustCompile(`<script>document\.domain="xunlei\.com";var btResult =(\{.+\});(var btRtcode = 0)*</script>`)
-	sub := exp.FindSubmatch(r)
-	if sub != nil {
+	if i := bytes.Index(r, []byte("var btResult =")); i >= 0 {
 		var result btUploadResponse
-		json.Unmarshal(sub[1], &result)
-		v := url.Values{}
-		v.Add("uid", s.uid)
-		v.Add("btname", result.Name) // TODO: filter illegal char
-		v.Add("cid", result.InfoId)
-		v.Add("tsize", strconv.Itoa(result.Size))
-		findex := make([]string, 0, len(result.List))
-		size := make([]string, 0, len(result.List))
-		for i := 0; i < len(result.List); i++ {
-			findex = append(findex, result.List[i].Id)
-			size = append(size, result.List[i].Size)
+		value, _, err := jsonp.ParseValue(r[i+len("var btResult ="):])
+		if err == nil {
+			err = jsonp.Unmarshal(value, &result)
 		}
-		v.Add("findex", strings.Join(findex, "_"))
-		v.Add("size", strings.Join(size, "_"))
-		v.Add("from", "0")
-		retryTimes := 10
-	retry:
+		if err != nil {
+			return nil, malformedResponse("torrent_upload", r, err)
+		}
+		p = &BtPreview{InfoId: result.InfoId, Name: unescapeName(result.Name), Size: int64(result.Size), Files: make([]BtEntry, len(result.List))}
+		for i, f := range result.List {
+			p.Files[i] = newBtEntry(f.Id, f.Name, f.Ext, f.Size, f.Valid == 1)
+		}
+		p.form = url.Values{}
+		p.form.Add("uid", s.userId())
+		p.form.Add("btname", result.Name) // TODO: filter illegal char
+		p.form.Add("cid", result.InfoId)
+		p.form.Add("tsize", strconv.Itoa(result.Size))
+		p.form.Add("from", "0")
+		return p, nil
+	}
+	if _, err = jsonp.Find(r, "edit_bt_list"); err != nil {
+		return nil, unexpectedResponse("torrent_upload", r)
+	}
+	return nil, newAPIError("torrent_upload", "", "", r, ErrTaskExisted)
+}
+
+// commitBtTask submits the bt task described by v,
+// consulting the captcha solver when the server asks for a verification code.
+func (s *session) commitBtTask(ctx context.Context, v url.Values) (string, error) {
+	for retryTimes := 10; ; retryTimes-- {
 		log.Debugf("submit bt: %s", v.Encode())
-		r, err = s.post(fmt.Sprintf(bttaskcommitURI, currentTimestamp()), v.Encode())
-		exp = regexp.MustCompile(`jsonp.*\((\{.*\})\)`)
+		r, err := s.commitForm(ctx, s.lixianURL(bttaskcommitURI, currentTimestamp()), v.Encode())
+		if err != nil {
+			return "", err
+		}
 		log.Debugf("bt submission response: %s", r)
-		sub = exp.FindSubmatch(r)
-		if sub == nil {
-			return errInvalidResponse
+		c, err := parseCall("bt_task_commit", r, "jsonp")
+		if err != nil {
+			return "", err
 		}
 		var submresp btSumbissionResponse
-		if err = json.Unmarshal(sub[1], &submresp); err != nil {
-			return err
+		if err = c.Decode(0, &submresp); err != nil {
+			return "", malformedResponse("bt_task_commit", r, err)
 		}
 		switch submresp.Progress {
 		case 1:
-			return nil
+			return submresp.Id, nil
 		case 2:
-			if submresp.ErrorMessage != nil {
-				return errors.New(submresp.Message)
-			}
-			return errTaskSubmissionFailed
+			return "", submresp.apiError(r)
 		case -11, -12:
 			if retryTimes <= 0 {
-				return errImageVerification
+				return "", ErrImageVerification
 			}
-			var w io.WriterTo
-			w, err = s.getVerifyImage()
-			os.Stdout.WriteString("\n")
-			w.WriteTo(os.Stdout)
-			os.Stdout.WriteString("input verify_code: ")
-			reader := bufio.NewReader(os.Stdin)
-			line, err := reader.ReadString('\n')
+			code, err := s.solveCaptcha(ctx)
 			if err != nil {
-				return err
+				return "", err
 			}
-			if len(line) > 4 {
-				line = line[:4]
-			}
-			v.Set("verify_code", line)
-			retryTimes--
-			goto retry
+			v.Set("verify_code", code)
 		default:
-			return errUnexpected
+			return "", unexpectedResponse("bt_task_commit", r)
 		}
-		return nil
-	}
-	exp = regexp.MustCompile(`parent\.edit_bt_list\((\{.*\}),'`)
-	sub = exp.FindSubmatch(r)
-	if sub == nil {
-		return errUnexpected
 	}
-	// var result _btup_result
-	// json.Unmarshal(sub[1], &result)
-	return errBtTaskExisted
 }
 
-func (s *session) processTask(callback TaskCallback) error {
-	tasks := s.cache.Tasks
+func (s *session) processTask(ctx context.Context, callback TaskCallback) error {
+	tasks := s.cache.snapshot()
 	l := len(tasks)
 	if l == 0 {
-		return errNoTasksInProgress
+		return ErrNoTasksInProgress
 	}
 	ct := currentTimestamp()
 	v := url.Values{}
@@ -1320,9 +1562,9 @@ func (s *session) processTask(callback TaskCallback) error {
 	nmList := make([]string, 0, l)
 	btList := make([]string, 0, l)
 	for i := range tasks {
-		if tasks[i].status() == flagNormal && tasks[i].DownloadStatus == "1" {
+		if tasks[i].normal() && tasks[i].downloading() {
 			list = append(list, tasks[i].Id)
-			if tasks[i].TaskType == 0 {
+			if tasks[i].IsBt() {
 				btList = append(btList, tasks[i].Id)
 			} else {
 				nmList = append(nmList, tasks[i].Id)
@@ -1332,25 +1574,29 @@ func (s *session) processTask(callback TaskCallback) error {
 	v.Add("list", strings.Join(list, ","))
 	v.Add("nm_list", strings.Join(nmList, ","))
 	v.Add("bt_list", strings.Join(btList, ","))
-	v.Add("uid", s.uid)
+	v.Add("uid", s.userId())
 	v.Add("interfrom", "task")
 	var r []byte
 	var err error
-	if r, err = s.post(fmt.Sprintf(taskprocessURI, ct, ct), v.Encode()); err != nil {
+	if r, err = s.post(ctx, s.lixianURL(taskprocessURI, ct, ct), v.Encode()); err != nil {
 		return err
 	}
-	exp := regexp.MustCompile(`jsonp\d+\(\{"Process":(.*)\}\)`)
-	sub := exp.FindSubmatch(r)
-	if sub == nil {
-		return errInvalidResponse
-	}
-	var res ptaskResponse
-	err = json.Unmarshal(sub[1], &res)
+	c, err := parseCall("task_process", r, "jsonp")
 	if err != nil {
 		return err
 	}
+	var resp struct {
+		Process *ptaskResponse
+	}
+	if err = c.Decode(0, &resp); err != nil || resp.Process == nil {
+		return invalidResponse("task_process", r)
+	}
+	res := resp.Process
 	for i := range res.List {
 		task := tasks[res.List[i].Id]
+		if task == nil {
+			continue
+		}
 		task.update(&res.List[i])
 		if callback != nil {
 			callback(task)
@@ -1359,39 +1605,46 @@ func (s *session) processTask(callback TaskCallback) error {
 	return nil
 }
 
-func (s *session) removeTask(t *Task, flag byte) error {
+func (s *session) removeTask(ctx context.Context, t *Task, flag byte) error {
 	var delType = t.status()
 	if delType == flagInvalid {
-		return errInvalidTaskFlag
+		return ErrInvalidTaskFlag
 	} else if delType == flagPurged {
-		return errTaskAlreadyPurged
+		return ErrTaskAlreadyPurged
 	} else if flag == 0 && delType == flagDeleted {
-		return errTaskAlreadyRemoved
+		return ErrTaskAlreadyRemoved
 	}
 	ct := currentTimestamp()
-	uri := fmt.Sprintf(taskdeleteURI, ct, delType, ct)
+	uri := s.lixianURL(taskdeleteURI, ct, delType, ct)
 	data := url.Values{}
 	data.Add("taskids", t.Id+",")
 	data.Add("databases", "0,")
 	data.Add("interfrom", "task")
-	r, err := s.post(uri, data.Encode())
+	r, err := s.post(ctx, uri, data.Encode())
+	if err != nil {
+		return err
+	}
+	c, err := parseCall("task_delete", r, "jsonp")
 	if err != nil {
 		return err
 	}
-	if ok, _ := regexp.Match(`\{"result":1,"type":`, r); ok {
+	var resp struct {
+		Result int `json:"result"`
+	}
+	if c.Decode(0, &resp) == nil && resp.Result == 1 {
 		log.Debugf("remove task: %s", r)
-		if t.status() == flagDeleted {
-			t.Flag = "2"
+		if t.deleted() {
+			t.Flag = strconv.Itoa(int(FlagPurged))
 		} else {
-			t.Flag = "1"
+			t.Flag = strconv.Itoa(int(FlagDeleted))
 		}
 		t.Progress = 0
 		return nil
 	}
-	return errUnexpected
+	return unexpectedResponse("task_delete", r)
 }
 
-func (s *session) tasklistNofresh(tid, page int) ([]byte, error) {
+func (s *session) tasklistNofresh(ctx context.Context, tid, page int) ([]byte, error) {
 	/*
 		tid:
 		1 downloading
@@ -1403,57 +1656,31 @@ func (s *session) tasklistNofresh(tid, page int) ([]byte, error) {
 	if tid != 4 && tid != 1 && tid != 2 {
 		tid = 4
 	}
-	uri := fmt.Sprintf(showtaskUnfreshURI, tid, page, pageSize, page)
-	log.Debugf("==> %s", uri)
-	req, err := http.NewRequest("GET", uri, nil)
+	uri := s.lixianURL(showtaskUnfreshURI, tid, page, pageSize, page)
+	req, err := newRequest("GET", uri, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("User-Agent", userAgent)
-	req.Header.Add("Accept-Encoding", "gzip, deflate")
 	req.AddCookie(&http.Cookie{Name: "pagenum", Value: pageSize})
-	resp, err := s.routine(req)
+	r, err := s.fetch(ctx, req, true)
 	if err != nil {
 		return nil, err
 	}
-	log.Debug(resp.Status)
-	r, err := readBody(resp)
-	resp.Body.Close()
+	c, err := parseCall("showtask_unfresh", r, "rebuild")
 	if err != nil {
 		return nil, err
 	}
-	exp := regexp.MustCompile(`rebuild\((\{.*\})\)`)
-	sub := exp.FindSubmatch(r)
-	if sub == nil {
-		return nil, errInvalidResponse
+	if len(c.Args) == 0 {
+		return nil, invalidResponse("showtask_unfresh", r)
 	}
-	return sub[1], nil
+	return c.Raw(0), nil
 }
 
-func (s *session) getVerifyImage() (w io.WriterTo, err error) {
-	uri := fmt.Sprintf(getVerifyURL(), currentTimestamp())
-	log.Debugf("==> %s", uri)
-	req, err := http.NewRequest("GET", uri, nil)
-	if err != nil {
-		return
-	}
-	req.Header.Add("User-Agent", userAgent)
-	resp, err := s.routine(req)
-	if err != nil {
-		return
-	}
-	log.Debug(resp.Status)
-	if resp.StatusCode/100 > 3 {
-		err = errors.New(resp.Status)
-		return
-	}
-	w, err = ascii.Decode(resp.Body, ascii.Options{
-		Invert: true,
-		Color:  true,
-	})
-	resp.Body.Close()
+func (s *session) getVerifyImage(ctx context.Context) (image []byte, err error) {
+	uri := fmt.Sprintf(s.verifyURL(), currentTimestamp())
+	req, err := newRequest("GET", uri, nil)
 	if err != nil {
 		return
 	}
-	return
+	return s.fetch(ctx, req, true)
 }
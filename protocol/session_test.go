@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -24,7 +25,7 @@ func init() {
 }
 
 func TestConn(t *testing.T) {
-	err := testSession.Login(conf.Id, conf.Pass)
+	_, err := testSession.Login(conf.Id, conf.Pass)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -37,7 +38,7 @@ func TestConn(t *testing.T) {
 }
 
 func TestTaskNoFresh(t *testing.T) {
-	_, err := testSession.tasklistNofresh(4, 1)
+	_, err := testSession.tasklistNofresh(context.Background(), 4, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -45,7 +46,7 @@ func TestTaskNoFresh(t *testing.T) {
 		for {
 			select {
 			case <-time.After(time.Second):
-				fmt.Println("tasks in cache:", len(testSession.cache.Tasks))
+				fmt.Println("tasks in cache:", testSession.cache.size())
 			}
 		}
 	}()
@@ -0,0 +1,281 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	sessionStateVersion = 1
+	pbkdf2Iterations    = 100000
+)
+
+var ErrInvalidPassphrase = errors.New("invalid passphrase for session store")
+
+// SessionState is the part of a session kept between runs.
+type SessionState struct {
+	Version int       `json:"version"`
+	SavedAt time.Time `json:"saved_at"`
+	// Cookies maps each cookie domain to its cookies; Expires is set on
+	// those whose expiry is known.
+	Cookies map[string][]*http.Cookie `json:"cookies"`
+	// Expires is the earliest expiry of the login cookies, zero if unknown.
+	Expires  time.Time `json:"expires"`
+	Account  string    `json:"account,omitempty"`
+	Passhash string    `json:"passhash,omitempty"`
+}
+
+// SessionStore persists a SessionState, see SaveSessionTo and ResumeSessionFrom.
+type SessionStore interface {
+	Load() (*SessionState, error)
+	Save(state *SessionState) error
+}
+
+type fileStore struct {
+	path       string
+	passphrase []byte
+}
+
+// NewFileStore keeps the state as JSON in path, readable by the owner only.
+func NewFileStore(path string) SessionStore {
+	return &fileStore{path: path}
+}
+
+// NewEncryptedFileStore is like NewFileStore, but encrypts the state with
+// AES-GCM under a key derived from passphrase.
+func NewEncryptedFileStore(path string, passphrase []byte) SessionStore {
+	return &fileStore{path: path, passphrase: passphrase}
+}
+
+// sealedState is the file format of an encrypted store.
+type sealedState struct {
+	Version    int    `json:"version"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+func (f *fileStore) Load() (*SessionState, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return legacyState(trimmed)
+	}
+	var sealed sealedState
+	if err = json.Unmarshal(data, &sealed); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSession, err)
+	}
+	if sealed.Data != nil {
+		if len(f.passphrase) == 0 {
+			return nil, ErrInvalidPassphrase
+		}
+		if data, err = f.open(&sealed); err != nil {
+			return nil, err
+		}
+	}
+	var state SessionState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSession, err)
+	}
+	if state.Version < 1 || state.Version > sessionStateVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidSession, state.Version)
+	}
+	return &state, nil
+}
+
+// legacyState migrates the cookie file of older versions, a JSON array of the
+// cookies of each of the default cookie domains, in order.
+func legacyState(data []byte) (*SessionState, error) {
+	var legacy [][]*http.Cookie
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSession, err)
+	}
+	if len(legacy) < 2 || len(legacy) > len(defaultCookieDomains) {
+		return nil, fmt.Errorf("%w: %d cookie domains in legacy session", ErrInvalidSession, len(legacy))
+	}
+	state := &SessionState{
+		Version: sessionStateVersion,
+		Cookies: make(map[string][]*http.Cookie, len(legacy)),
+	}
+	for i, cookies := range legacy {
+		state.Cookies[defaultCookieDomains[i]] = cookies
+	}
+	return state, nil
+}
+
+func (f *fileStore) Save(state *SessionState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if len(f.passphrase) > 0 {
+		if data, err = f.seal(data); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(f.path, data)
+}
+
+func (f *fileStore) seal(data []byte) ([]byte, error) {
+	sealed := sealedState{
+		Version:    sessionStateVersion,
+		Iterations: pbkdf2Iterations,
+		Salt:       make([]byte, 16),
+	}
+	if _, err := rand.Read(sealed.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := f.aead(sealed.Salt, sealed.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(sealed.Nonce); err != nil {
+		return nil, err
+	}
+	sealed.Data = aead.Seal(nil, sealed.Nonce, data, nil)
+	return json.MarshalIndent(&sealed, "", "  ")
+}
+
+func (f *fileStore) open(sealed *sealedState) ([]byte, error) {
+	aead, err := f.aead(sealed.Salt, sealed.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed.Nonce) != aead.NonceSize() {
+		return nil, ErrInvalidSession
+	}
+	data, err := aead.Open(nil, sealed.Nonce, sealed.Data, nil)
+	if err != nil {
+		return nil, ErrInvalidPassphrase
+	}
+	return data, nil
+}
+
+func (f *fileStore) aead(salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2.Key(f.passphrase, salt, iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeFileAtomic replaces path with data, so that neither a crash nor another
+// reader ever sees a partial file, and leaves it readable by the owner only.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// jar remembers when cookies expire, which http.CookieJar does not report.
+type jar struct {
+	*cookiejar.Jar
+	mutex   sync.Mutex
+	expires map[string]time.Time // by name=value
+}
+
+func newJar() *jar {
+	j, _ := cookiejar.New(nil)
+	return &jar{Jar: j, expires: make(map[string]time.Time)}
+}
+
+func (j *jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mutex.Lock()
+	now := time.Now()
+	for _, c := range cookies {
+		if c.MaxAge > 0 {
+			j.expires[c.Name+"="+c.Value] = now.Add(time.Duration(c.MaxAge) * time.Second)
+		} else if !c.Expires.IsZero() {
+			j.expires[c.Name+"="+c.Value] = c.Expires
+		}
+	}
+	j.mutex.Unlock()
+	j.Jar.SetCookies(u, cookies)
+}
+
+// expiring returns the cookies for u with their expiry, if known.
+func (j *jar) expiring(u *url.URL) []*http.Cookie {
+	cookies := j.Cookies(u)
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for _, c := range cookies {
+		c.Expires = j.expires[c.Name+"="+c.Value]
+	}
+	return cookies
+}
+
+// state captures the cookies and credentials of s; authMutex must be held.
+func (s *session) state() *SessionState {
+	state := &SessionState{
+		Version: sessionStateVersion,
+		SavedAt: time.Now(),
+		Cookies: make(map[string][]*http.Cookie, len(s.cookieURLs)),
+	}
+	for i, u := range s.cookieURLs {
+		cookies := s.jar.expiring(u)
+		for _, c := range cookies {
+			if i == 0 && !c.Expires.IsZero() && (state.Expires.IsZero() || c.Expires.Before(state.Expires)) {
+				state.Expires = c.Expires
+			}
+		}
+		state.Cookies[u.String()] = cookies
+	}
+	s.lock()
+	state.Account, state.Passhash = s.loginId, s.passhash
+	s.unlock()
+	return state
+}
+
+// restore loads the cookies and credentials in state into s, dropping
+// expired cookies; authMutex must be held.
+func (s *session) restore(state *SessionState) {
+	now := time.Now()
+	for _, u := range s.cookieURLs {
+		cookies := make([]*http.Cookie, 0, len(state.Cookies[u.String()]))
+		for _, c := range state.Cookies[u.String()] {
+			if c.Expires.IsZero() || c.Expires.After(now) {
+				cookies = append(cookies, c)
+			}
+		}
+		s.jar.SetCookies(u, cookies)
+	}
+	if len(state.Account) > 0 {
+		s.lock()
+		s.loginId, s.passhash = state.Account, state.Passhash
+		s.unlock()
+	}
+}
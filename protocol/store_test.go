@@ -0,0 +1,118 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xunlei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cookie.json")
+
+	s := newSession(time.Second)
+	u := s.cookieURLs[0]
+	s.jar.SetCookies(u, []*http.Cookie{
+		{Name: "userid", Value: "42", MaxAge: 3600},
+		{Name: "sessionid", Value: "abc"},
+	})
+	s.loginId, s.passhash = "me", EncryptPass("secret")
+	for _, store := range []SessionStore{NewFileStore(path), NewEncryptedFileStore(path, []byte("passphrase"))} {
+		if err = s.SaveSessionTo(store); err != nil {
+			t.Fatal(err)
+		}
+		if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+			t.Errorf("expected mode 0600, got %v, %v", fi.Mode(), err)
+		}
+		state, err := store.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.Version != sessionStateVersion || state.Account != "me" || len(state.Cookies[u.String()]) != 2 {
+			t.Errorf("unexpected state: %+v", state)
+		}
+		if state.Expires.Before(time.Now().Add(59 * time.Minute)) {
+			t.Errorf("expected cookie expiry to be recorded, got %v", state.Expires)
+		}
+	}
+	data, _ := ioutil.ReadFile(path)
+	if bytes.Contains(data, []byte("sessionid")) {
+		t.Error("encrypted store leaks cookies")
+	}
+	if _, err = NewEncryptedFileStore(path, []byte("wrong")).Load(); err != ErrInvalidPassphrase {
+		t.Errorf("expected ErrInvalidPassphrase, got %v", err)
+	}
+	if _, err = NewFileStore(path).Load(); err != ErrInvalidPassphrase {
+		t.Errorf("expected ErrInvalidPassphrase without passphrase, got %v", err)
+	}
+}
+
+func TestRestoreDropsExpiredCookies(t *testing.T) {
+	s := newSession(time.Second)
+	u := s.cookieURLs[0]
+	s.restore(&SessionState{
+		Version: sessionStateVersion,
+		Cookies: map[string][]*http.Cookie{u.String(): {
+			{Name: "userid", Value: "42", Expires: time.Now().Add(-time.Hour)},
+			{Name: "sessionid", Value: "abc", Expires: time.Now().Add(time.Hour)},
+		}},
+		Account: "me",
+	})
+	if s.getCookie("userid") != "" || s.getCookie("sessionid") != "abc" {
+		t.Errorf("unexpected cookies: %v", s.jar.Cookies(u))
+	}
+	if s.loginId != "me" {
+		t.Errorf("expected credentials to be restored, got %q", s.loginId)
+	}
+}
+
+func TestFileStoreLegacy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xunlei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cookie.json")
+	legacy := `[
+  [
+    {"Name": "userid", "Value": "42", "Path": "/", "Domain": "", "Expires": "0001-01-01T00:00:00Z", "RawExpires": "", "MaxAge": 0, "Secure": false, "HttpOnly": false, "Raw": "", "Unparsed": null},
+    {"Name": "sessionid", "Value": "abc", "Path": "/", "Domain": "", "Expires": "0001-01-01T00:00:00Z", "RawExpires": "", "MaxAge": 0, "Secure": false, "HttpOnly": false, "Raw": "", "Unparsed": null}
+  ],
+  [],
+  [
+    {"Name": "gdriveid", "Value": "C0FFEE", "Path": "/", "Domain": "", "Expires": "0001-01-01T00:00:00Z", "RawExpires": "", "MaxAge": 0, "Secure": false, "HttpOnly": false, "Raw": "", "Unparsed": null}
+  ]
+]`
+	if err = ioutil.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, store := range []SessionStore{NewFileStore(path), NewEncryptedFileStore(path, []byte("passphrase"))} {
+		state, err := store.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := newSession(time.Second)
+		s.restore(state)
+		if uid := s.getCookie("userid"); uid != "42" {
+			t.Errorf("expected legacy cookies to be restored, got userid %q", uid)
+		}
+		if cookies := s.jar.Cookies(s.cookieURLs[2]); len(cookies) == 0 {
+			t.Error("expected the cookies of the third domain to be restored")
+		}
+	}
+	if err = ioutil.WriteFile(path, []byte(`[[]]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewFileStore(path).Load(); !errors.Is(err, ErrInvalidSession) {
+		t.Errorf("expected ErrInvalidSession, got %v", err)
+	}
+}
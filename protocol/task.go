@@ -3,8 +3,83 @@ package protocol
 import (
 	"fmt"
 	"strconv"
+	"time"
 )
 
+// TaskKind is the kind of a listed task, as reported in its tasktype field.
+type TaskKind byte
+
+const (
+	KindBt TaskKind = iota
+	KindOrdinary
+	KindEd2k
+)
+
+func (k TaskKind) String() string {
+	switch k {
+	case KindBt:
+		return "bt"
+	case KindOrdinary:
+		return "ordinary"
+	case KindEd2k:
+		return "ed2k"
+	}
+	return "unknown(" + strconv.Itoa(int(k)) + ")"
+}
+
+// TaskStatus is the download status of a task.
+type TaskStatus int
+
+const (
+	StatusUnknown     TaskStatus = -1
+	StatusWaiting     TaskStatus = 0
+	StatusDownloading TaskStatus = 1
+	StatusCompleted   TaskStatus = 2
+	StatusFailed      TaskStatus = 3
+	StatusPending     TaskStatus = 5
+)
+
+func (s TaskStatus) String() string {
+	switch s {
+	case StatusWaiting:
+		return "waiting"
+	case StatusDownloading:
+		return "downloading"
+	case StatusCompleted:
+		return "completed"
+	case StatusFailed:
+		return "failed"
+	case StatusPending:
+		return "pending"
+	}
+	return "unknown"
+}
+
+// TaskFlag tells whether a task is listed, deleted, purged or expired.
+type TaskFlag int
+
+const (
+	FlagUnknown TaskFlag = -1
+	FlagNormal  TaskFlag = 0
+	FlagDeleted TaskFlag = 1
+	FlagPurged  TaskFlag = 2
+	FlagExpired TaskFlag = 4
+)
+
+func (f TaskFlag) String() string {
+	switch f {
+	case FlagNormal:
+		return "normal"
+	case FlagDeleted:
+		return "deleted"
+	case FlagPurged:
+		return "purged"
+	case FlagExpired:
+		return "expired"
+	}
+	return "unknown"
+}
+
 var (
 	stats = []string{
 		colorBgYellow + "waiting" + colorReset,
@@ -79,7 +154,7 @@ type Task struct {
 	Id   string `json:"id"`
 	Flag string `json:"flag"`
 	// Database            string `json:"database"`
-	// ClassValue          string `json:"class_value"`
+	ClassValue     string  `json:"class_value"`
 	GlobalId       string  `json:"global_id"`
 	ResType        string  `json:"restype"`
 	FileSize       string  `json:"filesize"`
@@ -99,7 +174,7 @@ type Task struct {
 	// Vod                 string `json:"vod"`
 	Status string `json:"status"`
 	// Message             string `json:"message"`
-	// DtCommitted         string `json:"dt_committed"`
+	DtCommitted string `json:"dt_committed"`
 	// DtDeleted           string `json:"dt_deleted"`
 	// ListSum             string `json:"list_sum"`
 	// FinishSum           string `json:"finish_sum"`
@@ -126,13 +201,8 @@ type Task struct {
 }
 
 func (t Task) Coloring() string {
-	j, _ := strconv.Atoi(t.DownloadStatus)
-	k, _ := strconv.Atoi(t.Flag)
-	if k == 4 {
-		j += k
-	}
-	status := stats[j]
-	return fmt.Sprintf("%s%s %s %s %s%s %.1f%% %s%s", coloring[j], t.Id, t.TaskName, status, coloring[j], t.FileSize, t.Progress, trimHTMLFontTag(t.LeftLiveTime), colorReset)
+	status, color := t.display()
+	return fmt.Sprintf("%s%s %s %s %s%s %.1f%% %s%s", color, t.Id, t.TaskName, status, color, t.FileSize, t.Progress, trimHTMLFontTag(t.LeftLiveTime), colorReset)
 }
 
 func (t Task) String() string {
@@ -140,13 +210,8 @@ func (t Task) String() string {
 }
 
 func (t Task) Repr() string {
-	j, _ := strconv.Atoi(t.DownloadStatus)
-	k, _ := strconv.Atoi(t.Flag)
-	if k == 4 {
-		j += k
-	}
-	status := stats[j]
-	ret := coloring[j] + t.Id + " " + t.TaskName + " " + status + coloring[j] + " " + t.FileSize + " " + trimHTMLFontTag(t.LeftLiveTime) + "\n"
+	status, color := t.display()
+	ret := color + t.Id + " " + t.TaskName + " " + status + color + " " + t.FileSize + " " + trimHTMLFontTag(t.LeftLiveTime) + "\n"
 	if t.Cid != "" {
 		ret += t.Cid + " "
 	}
@@ -160,6 +225,80 @@ func (t Task) Repr() string {
 	return ret + colorReset
 }
 
+// display returns the colored status shown for t, and its color.
+func (t Task) display() (string, string) {
+	var j int
+	switch t.State() {
+	case StatusWaiting:
+		j = 0
+	case StatusDownloading:
+		j = 1
+	case StatusCompleted:
+		j = 2
+	case StatusFailed:
+		j = 3
+	case StatusPending:
+		j = 4
+	default:
+		return "unknown", colorReset
+	}
+	if t.expired() {
+		j = 5
+	}
+	return stats[j], coloring[j]
+}
+
+// Kind returns the kind of t.
+func (t Task) Kind() TaskKind {
+	return TaskKind(t.TaskType)
+}
+
+// State returns the download status of t, or StatusUnknown if the server
+// reported an unexpected one.
+func (t Task) State() TaskStatus {
+	switch s, err := strconv.Atoi(t.DownloadStatus); {
+	case err != nil:
+	case TaskStatus(s) >= StatusWaiting && TaskStatus(s) <= StatusFailed, TaskStatus(s) == StatusPending:
+		return TaskStatus(s)
+	}
+	return StatusUnknown
+}
+
+// Lifecycle returns whether t is listed, deleted, purged or expired, or
+// FlagUnknown if the server reported an unexpected flag.
+func (t Task) Lifecycle() TaskFlag {
+	if t.Flag == "" {
+		return FlagNormal
+	}
+	switch f, err := strconv.Atoi(t.Flag); {
+	case err != nil:
+	case TaskFlag(f) >= FlagNormal && TaskFlag(f) <= FlagPurged, TaskFlag(f) == FlagExpired:
+		return TaskFlag(f)
+	}
+	return FlagUnknown
+}
+
+// Size returns the size of t in bytes, or 0 if unknown.
+func (t Task) Size() int64 {
+	if n, err := strconv.ParseInt(t.YsFileSize, 10, 64); err == nil && n > 0 {
+		return n
+	}
+	n, _ := parseSize(t.FileSize)
+	return n
+}
+
+// BytesPerSecond returns the download speed of t, or 0 if unknown.
+func (t Task) BytesPerSecond() int64 {
+	n, _ := parseSize(t.Speed)
+	return n
+}
+
+// LiveTime returns how long t remains available on the server; ok is false
+// if the server reported no such duration, e.g. for expired tasks.
+func (t Task) LiveTime() (d time.Duration, ok bool) {
+	return parseLiveTime(t.LeftLiveTime)
+}
+
 func (t Task) expired() bool {
 	return t.status() == flagExpired
 }
@@ -177,38 +316,33 @@ func (t Task) normal() bool {
 }
 
 func (t Task) IsBt() bool {
-	return t.TaskType == 0
+	return t.Kind() == KindBt
 }
 
 func (t Task) waiting() bool {
-	return t.DownloadStatus == "0"
+	return t.State() == StatusWaiting
 }
 
 func (t Task) completed() bool {
-	return t.DownloadStatus == "2"
+	return t.State() == StatusCompleted
 }
 
 func (t Task) downloading() bool {
-	return t.DownloadStatus == "1"
+	return t.State() == StatusDownloading
 }
 
 func (t Task) failed() bool {
-	return t.DownloadStatus == "3"
+	return t.State() == StatusFailed
 }
 
 func (t Task) pending() bool {
-	return t.DownloadStatus == "5"
+	return t.State() == StatusPending
 }
 
+// status returns the cache group of t.
 func (t Task) status() byte {
-	switch len(t.Flag) {
-	case 0:
-		return flagNormal
-	case 1:
-		t := t.Flag[0] - '0'
-		if t < 5 {
-			return t
-		}
+	if f := t.Lifecycle(); f != FlagUnknown {
+		return byte(f)
 	}
 	return flagInvalid
 }
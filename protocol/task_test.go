@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTaskAccessors(t *testing.T) {
+	task := Task{
+		DownloadStatus: "2",
+		Flag:           "4",
+		TaskType:       1,
+		FileSize:       "1.5G",
+		Speed:          "512K",
+		LeftLiveTime:   "<font color='#FF0000'>23小时</font>",
+	}
+	if task.State() != StatusCompleted || task.Lifecycle() != FlagExpired || task.Kind() != KindOrdinary || task.IsBt() {
+		t.Errorf("unexpected state: %v %v %v", task.State(), task.Lifecycle(), task.Kind())
+	}
+	if task.Size() != 3<<29 {
+		t.Errorf("unexpected size: %d", task.Size())
+	}
+	task.YsFileSize = "1610612737"
+	if task.Size() != 1610612737 {
+		t.Errorf("unexpected size: %d", task.Size())
+	}
+	if task.BytesPerSecond() != 512<<10 {
+		t.Errorf("unexpected speed: %d", task.BytesPerSecond())
+	}
+	if d, ok := task.LiveTime(); !ok || d != 23*time.Hour {
+		t.Errorf("unexpected live time: %v, %v", d, ok)
+	}
+	if d, ok := (Task{LeftLiveTime: "30"}).LiveTime(); !ok || d != 30*24*time.Hour {
+		t.Errorf("unexpected live time: %v, %v", d, ok)
+	}
+	if !strings.Contains(task.Coloring(), "expired") {
+		t.Errorf("expected expired task, got %q", task.Coloring())
+	}
+
+	unknown := Task{DownloadStatus: "9", Flag: "x", TaskType: 7, FileSize: "-", LeftLiveTime: "已过期"}
+	if unknown.State() != StatusUnknown || unknown.Lifecycle() != FlagUnknown || unknown.Kind().String() != "unknown(7)" {
+		t.Errorf("unexpected state: %v %v %v", unknown.State(), unknown.Lifecycle(), unknown.Kind())
+	}
+	if unknown.status() != flagInvalid || unknown.Size() != 0 || unknown.BytesPerSecond() != 0 {
+		t.Errorf("unexpected values: %d %d %d", unknown.status(), unknown.Size(), unknown.BytesPerSecond())
+	}
+	if _, ok := unknown.LiveTime(); ok {
+		t.Error("expected unknown live time")
+	}
+	if !strings.Contains(unknown.Coloring(), "unknown") || !strings.Contains(unknown.Repr(), "unknown") {
+		t.Errorf("expected unknown status, got %q", unknown.Coloring())
+	}
+	if pending := (Task{DownloadStatus: "5"}); !strings.Contains(pending.Coloring(), "pending") {
+		t.Errorf("expected pending status, got %q", pending.Coloring())
+	}
+}
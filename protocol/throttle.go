@@ -0,0 +1,114 @@
+package protocol
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// throttle bounds the number of requests in flight,
+// and the rate of requests sent to each host.
+type throttle struct {
+	inflight chan struct{}
+	rate     float64
+	burst    int
+	mutex    sync.Mutex
+	hosts    map[string]*rateLimiter
+}
+
+func newThrottle(maxInFlight int, rate float64, burst int) *throttle {
+	t := &throttle{
+		rate:  rate,
+		burst: burst,
+		hosts: make(map[string]*rateLimiter),
+	}
+	if maxInFlight > 0 {
+		t.inflight = make(chan struct{}, maxInFlight)
+	}
+	if t.burst <= 0 {
+		t.burst = 1
+	}
+	return t
+}
+
+// acquire blocks until a request to host may be sent;
+// the returned func must be called once the request is done.
+func (t *throttle) acquire(ctx context.Context, host string) (release func(), err error) {
+	if t.inflight != nil {
+		select {
+		case t.inflight <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			if t.inflight != nil {
+				<-t.inflight
+			}
+		})
+	}
+	if t.rate > 0 {
+		if err = t.limiter(host).wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+func (t *throttle) limiter(host string) *rateLimiter {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	l, ok := t.hosts[host]
+	if !ok {
+		l = &rateLimiter{
+			rate:   t.rate,
+			burst:  float64(t.burst),
+			tokens: float64(t.burst),
+			last:   time.Now(),
+		}
+		t.hosts[host] = l
+	}
+	return l
+}
+
+// rateLimiter is a token bucket refilled at rate tokens per second.
+type rateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mutex.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mutex.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mutex.Lock()
+		l.tokens++
+		l.mutex.Unlock()
+		return ctx.Err()
+	}
+}
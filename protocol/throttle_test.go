@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestThrottleInFlight(t *testing.T) {
+	th := newThrottle(2, 0, 0)
+	r1, _ := th.acquire(context.Background(), "a")
+	r2, _ := th.acquire(context.Background(), "b")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := th.acquire(ctx, "c"); err != context.DeadlineExceeded {
+		t.Errorf("expected third request to block, got %v", err)
+	}
+	r1()
+	r1() // releasing twice must not free another slot
+	if _, err := th.acquire(context.Background(), "c"); err != nil {
+		t.Error(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := th.acquire(ctx, "d"); err != context.DeadlineExceeded {
+		t.Errorf("expected fourth request to block, got %v", err)
+	}
+	r2()
+}
+
+func TestThrottleRate(t *testing.T) {
+	th := newThrottle(-1, 50, 1)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		release, err := th.acquire(context.Background(), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("requests not rate limited: %v", d)
+	}
+	start = time.Now()
+	release, _ := th.acquire(context.Background(), "b") // other hosts have their own bucket
+	release()
+	if d := time.Since(start); d > 10*time.Millisecond {
+		t.Errorf("request to another host delayed: %v", d)
+	}
+}
@@ -1,5 +1,19 @@
 package protocol
 
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+)
+
+const (
+	loginAttempts            = 5
+	loginResultBadVerifyCode = "1"
+)
+
 type loginResponse struct {
 	Result int `json:"result"`
 	Data   struct {
@@ -51,3 +65,64 @@ type UserInfo struct {
 	Isp            bool   `json:"isp"`
 	Percent        string `json:"percent"`
 }
+
+// LoginResult describes the user a Session has logged in as.
+type LoginResult struct {
+	UserId    string
+	UserName  string
+	UserNewno string
+	UserType  string
+	Nickname  string
+	VipState  string
+}
+
+func (r *LoginResult) IsVip() bool {
+	return r.VipState != "" && r.VipState != "0"
+}
+
+// loginCheck asks login.xunlei.com for the verification code of this login;
+// when an image code is demanded it is routed through the captcha solver.
+// An empty code means the check should be repeated.
+func (s *session) loginCheck(ctx context.Context, id string) (string, error) {
+	if _, err := s.get(ctx, s.loginURI+fmt.Sprintf(logincheckURI, id, currentTimestamp())); err != nil {
+		return "", err
+	}
+	result := s.getCookie("check_result")
+	switch {
+	case strings.HasPrefix(result, "0:") && len(result) > 2:
+		vcode := strings.ToUpper(result[2:])
+		log.Infof("verify_code: %s", vcode)
+		return vcode, nil
+	case strings.HasPrefix(result, "1:"):
+		log.Info("image verification required")
+		vcode, err := s.solveCaptcha(ctx)
+		return strings.ToUpper(vcode), err
+	}
+	return "", nil
+}
+
+func (s *session) verifyLogin(ctx context.Context) (*LoginResult, error) {
+	r, err := s.get(ctx, s.lixianURL(verifyLoginURI))
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseCall("verify_login", r, "")
+	if err != nil {
+		return nil, err
+	}
+	var resp loginResponse
+	if err = c.Decode(0, &resp); err != nil {
+		return nil, malformedResponse("verify_login", r, err)
+	}
+	if resp.Result != 1 {
+		return nil, &APIError{Endpoint: "verify_login", Code: strconv.Itoa(resp.Result), Body: r, Err: ErrLoginFailed}
+	}
+	return &LoginResult{
+		UserId:    resp.Data.UserId,
+		UserName:  resp.Data.UserName,
+		UserNewno: resp.Data.UserNewno,
+		UserType:  resp.Data.UserType,
+		Nickname:  resp.Data.Nickname,
+		VipState:  resp.Data.VipState,
+	}, nil
+}
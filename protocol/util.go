@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/zyxar/ed2k"
+	"github.com/zyxar/xunlei/protocol/jsonp"
 )
 
 func readBody(resp *http.Response) ([]byte, error) {
@@ -26,7 +27,9 @@ func readBody(resp *http.Response) ([]byte, error) {
 	var err error
 	switch resp.Header.Get("Content-Encoding") {
 	case "gzip":
-		rd, _ = gzip.NewReader(resp.Body)
+		if rd, err = gzip.NewReader(resp.Body); err != nil {
+			return nil, err
+		}
 		defer rd.Close()
 	case "deflate":
 		rd = flate.NewReader(resp.Body)
@@ -49,17 +52,16 @@ func readBody(resp *http.Response) ([]byte, error) {
 	return buffer.Bytes(), err
 }
 
-func parseHistory(in []byte, ty string) ([]*Task, bool) {
-	es := `<input id="d_status(\d+)"[^<>]+value="(.*)" />\s+<input id="dflag\d+"[^<>]+value="(.*)" />\s+<input id="dcid\d+"[^<>]+value="(.*)" />\s+<input id="f_url\d+"[^<>]+value="(.*)" />\s+<input id="taskname\d+"[^<>]+value="(.*)" />\s+<input id="d_tasktype\d+"[^<>]+value="(.*)" />`
-	exp := regexp.MustCompile(es)
-	s := exp.FindAllSubmatch(in, -1)
-	ret := make([]*Task, len(s))
-	for i := range s {
-		b, _ := strconv.Atoi(string(s[i][7]))
-		ret[i] = &Task{Id: string(s[i][1]), DownloadStatus: string(s[i][2]), Cid: string(s[i][4]), URL: string(s[i][5]), TaskName: unescapeName(string(s[i][6])), TaskType: byte(b), Flag: ty}
-	}
-	exp = regexp.MustCompile(`<li class="next"><a href="([^"]+)">[^<>]*</a></li>`)
-	return ret, exp.FindSubmatch(in) != nil
+// releaseReadCloser releases the resources held by a request once its body is closed.
+type releaseReadCloser struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.release()
+	return err
 }
 
 func currentTimestamp() int64 {
@@ -106,76 +108,84 @@ func md5sum(raw interface{}) []byte {
 	return h.Sum(nil)
 }
 
+// parseCall parses the response of endpoint as a call of a function whose
+// name starts with prefix, as the names of JSONP callbacks carry a timestamp.
+func parseCall(endpoint string, r []byte, prefix string) (*jsonp.Call, error) {
+	c, err := jsonp.Parse(r)
+	if err != nil {
+		return nil, malformedResponse(endpoint, r, err)
+	}
+	if !strings.HasPrefix(c.Name, prefix) {
+		return nil, malformedResponse(endpoint, r, fmt.Errorf("unexpected call of %q", c.Name))
+	}
+	return c, nil
+}
+
+// findAlert returns the message of an alert() in r.
+func findAlert(r []byte) (string, bool) {
+	c, err := jsonp.Find(r, "alert")
+	if err != nil {
+		return "", false
+	}
+	msg, _ := c.String(0)
+	return msg, true
+}
+
 func getTaskPre(resp []byte) (*taskPrepare, error) {
-	exp := regexp.MustCompile(`queryCid\((.*)\)`)
-	s := exp.FindSubmatch(resp)
-	if s == nil {
-		return nil, errInvalidResponse
+	c, err := parseCall("task_check", resp, "queryCid")
+	if err != nil {
+		return nil, err
 	}
-	ss := bytes.Split(s[1], []byte(","))
 	j := 0
-	if len(ss) >= 10 {
+	if len(c.Args) >= 10 {
 		j = 1
 	}
-	ret := taskPrepare{}
-	ret.Cid = string(bytes.Trim(ss[0], "' "))
-	ret.GCid = string(bytes.Trim(ss[1], "' "))
-	ret.SizeCost = string(bytes.Trim(ss[2], "' "))
-	ret.FileName = string(bytes.Trim(ss[j+3], "' "))
-	ret.Goldbean = string(bytes.Trim(ss[j+4], "' "))
-	ret.Silverbean = string(bytes.Trim(ss[j+5], "' "))
-	var err error
+	var fields [6]string
+	for i, k := range []int{0, 1, 2, j + 3, j + 4, j + 5} {
+		if fields[i], err = c.String(k); err != nil {
+			return nil, malformedResponse("task_check", resp, err)
+		}
+	}
+	ret := taskPrepare{
+		Cid:        fields[0],
+		GCid:       fields[1],
+		SizeCost:   fields[2],
+		FileName:   fields[3],
+		Goldbean:   fields[4],
+		Silverbean: fields[5],
+	}
 	if ret.Goldbean != "0" || ret.Silverbean != "0" {
-		err = fmt.Errorf("Task need bean: %s:%s", ret.Goldbean, ret.Silverbean)
+		err = &APIError{
+			Endpoint: "task_check",
+			Message:  fmt.Sprintf("task need bean: %s:%s", ret.Goldbean, ret.Silverbean),
+			Body:     resp,
+			Err:      ErrNeedBean,
+		}
 	}
 	return &ret, err
 }
 
-func parseBtQueryResponse(queryURL []byte) *btQueryResponse {
-	// exp := regexp.MustCompile(`'([0-9A-Za-z]{40,40})','(\d*)','(.*)','(\d)',new Array\((.*)\),new Array\((.*)\),new Array\((.*)\),new Array\((.*)\),new Array\((.*)\),new Array\((.*)\),'([\d\.]+)','(\d)'`)
-	exp := regexp.MustCompile(`'([0-9A-Za-z]{40,40})','(\d*)','(.*)','(\d)',new Array\((.*)\),new Array\((.*)\),new Array\((.*)\),new Array\((.*)\),new Array\((.*)\),new Array\((.*)\),new Array\((.*)\),'([\d\.]+)','(\d)'`)
-	s := exp.FindSubmatch(queryURL)
-	if s == nil {
-		return nil
-	}
+// parseBtQueryResponse decodes the arguments of
+// queryUrl(1,infohash,size,name,is_full,files,sizesf,sizes,picked,exts,indexes,valid,random,ret).
+func parseBtQueryResponse(c *jsonp.Call) (*btQueryResponse, error) {
 	var resp btQueryResponse
-	resp.InfoId = string(s[1])
-	resp.Size = string(s[2])
-	resp.Name = string(s[3])
-	resp.IsFull = string(s[4])
-	a := bytes.Split(s[5], []byte(","))
-	resp.Files = make([]string, len(a))
-	for i := 0; i < len(a); i++ {
-		resp.Files[i] = string(bytes.Trim(a[i], "' "))
-	}
-	a = bytes.Split(s[6], []byte(","))
-	resp.Sizesf = make([]string, len(a))
-	for i := 0; i < len(a); i++ {
-		resp.Sizesf[i] = string(bytes.Trim(a[i], "' "))
-	}
-	a = bytes.Split(s[7], []byte(","))
-	resp.Sizes = make([]string, len(a))
-	for i := 0; i < len(a); i++ {
-		resp.Sizes[i] = string(bytes.Trim(a[i], "' "))
-	}
-	a = bytes.Split(s[8], []byte(","))
-	resp.Picked = make([]string, len(a))
-	for i := 0; i < len(a); i++ {
-		resp.Picked[i] = string(bytes.Trim(a[i], "' "))
-	}
-	a = bytes.Split(s[9], []byte(","))
-	resp.Ext = make([]string, len(a))
-	for i := 0; i < len(a); i++ {
-		resp.Ext[i] = string(bytes.Trim(a[i], "' "))
-	}
-	a = bytes.Split(s[10], []byte(","))
-	resp.Index = make([]string, len(a))
-	for i := 0; i < len(a); i++ {
-		resp.Index[i] = string(bytes.Trim(a[i], "' "))
-	}
-	resp.Random = s[12]
-	resp.Ret = s[13]
-	return &resp
+	var err error
+	for i, f := range []*string{&resp.InfoId, &resp.Size, &resp.Name, &resp.IsFull} {
+		if *f, err = c.String(i + 1); err != nil {
+			return nil, err
+		}
+	}
+	for i, f := range []*[]string{&resp.Files, &resp.Sizesf, &resp.Sizes, &resp.Picked, &resp.Ext, &resp.Index} {
+		if *f, err = c.Strings(i + 5); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.Args) < 14 {
+		return nil, fmt.Errorf("jsonp: %s has %d arguments, want 14", c.Name, len(c.Args))
+	}
+	resp.Random = c.Raw(12)
+	resp.Ret = c.Raw(13)
+	return &resp, nil
 }
 
 func extractTasks(ts []*Task) (urls []string, ids []string) {
@@ -221,19 +231,67 @@ func unescapeName(s string) string {
 }
 
 func trimHTMLFontTag(raw string) string {
-	exp := regexp.MustCompile(`<font color='([a-z]*)'>(.*)</font>`)
+	exp := regexp.MustCompile(`<font[^<>]*>(.*)</font>`)
 	sub := exp.FindStringSubmatch(raw)
 	if sub == nil {
 		return raw
 	}
-	return sub[2]
+	return sub[1]
+}
+
+var (
+	sizePattern     = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([KMGTP]?)i?B?$`)
+	liveTimePattern = regexp.MustCompile(`^([0-9]+)\s*(天|小时|分钟|秒)?$`)
+	liveTimeUnits   = map[string]time.Duration{
+		"":   24 * time.Hour,
+		"天":  24 * time.Hour,
+		"小时": time.Hour,
+		"分钟": time.Minute,
+		"秒":  time.Second,
+	}
+)
+
+// parseSize parses a size as reported by the server, either a number of
+// bytes or a number with a binary unit, e.g. "700M" or "1.5GB".
+func parseSize(raw string) (int64, bool) {
+	sub := sizePattern.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(raw)))
+	if sub == nil {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(sub[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	if sub[2] != "" {
+		f *= float64(int64(1) << (10 * uint(strings.Index("KMGTP", sub[2])+1)))
+	}
+	return int64(f), true
 }
 
-var getVerifyURL = func() func() string {
-	counter := 0
-	return func() (url string) {
-		url = verifyBaseURLs[counter%len(verifyBaseURLs)]
-		counter++
-		return
+// formatSize formats n bytes with a binary unit, as the server does.
+func formatSize(n int64) string {
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < 5 {
+		f /= 1024
+		i++
 	}
-}()
+	if i == 0 {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	return strconv.FormatFloat(f, 'f', 1, 64) + "KMGTP"[i-1:i]
+}
+
+// parseLiveTime parses the remaining live time of a task, a number of days
+// or a number with a unit, possibly wrapped in a font tag.
+func parseLiveTime(raw string) (time.Duration, bool) {
+	sub := liveTimePattern.FindStringSubmatch(strings.TrimSpace(trimHTMLFontTag(raw)))
+	if sub == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(sub[1])
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(n) * liveTimeUnits[sub[2]], true
+}
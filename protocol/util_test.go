@@ -1,7 +1,8 @@
 package protocol
 
 import (
-	"os"
+	"context"
+	"sync"
 	"testing"
 )
 
@@ -19,8 +20,9 @@ func TestUnescapeName(t *testing.T) {
 }
 
 func TestGetVerifyURL(t *testing.T) {
+	getVerifyURL := newVerifyURLFunc(defaultVerifyURLs)
 	for i := 0; i < 100; i++ {
-		url1 := verifyBaseURLs[i%len(verifyBaseURLs)]
+		url1 := defaultVerifyURLs[i%len(defaultVerifyURLs)]
 		url2 := getVerifyURL()
 		if url1 != url2 {
 			t.Errorf("URL not match: expected %s, got %s", url1, url2)
@@ -28,12 +30,38 @@ func TestGetVerifyURL(t *testing.T) {
 	}
 }
 
+func TestGetVerifyURLConcurrently(t *testing.T) {
+	getVerifyURL := newVerifyURLFunc(defaultVerifyURLs)
+	ch := make(chan string, 10*len(defaultVerifyURLs))
+	var wg sync.WaitGroup
+	for i := 0; i < cap(ch); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ch <- getVerifyURL()
+		}()
+	}
+	wg.Wait()
+	close(ch)
+	count := make(map[string]int)
+	for url := range ch {
+		count[url]++
+	}
+	for _, url := range defaultVerifyURLs {
+		if count[url] != 10 {
+			t.Errorf("expected %s to be used 10 times, got %d", url, count[url])
+		}
+	}
+}
+
 func TestGetVerifyImage(t *testing.T) {
 	for i := 0; i < 10; i++ {
-		w, err := defaultSession.(*session).getVerifyImage()
+		image, err := defaultSession.(*session).getVerifyImage(context.Background())
 		if err != nil {
 			t.Error(err)
 		}
-		w.WriteTo(os.Stdout)
+		if len(image) == 0 {
+			t.Error("empty verify image")
+		}
 	}
 }
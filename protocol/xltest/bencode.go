@@ -0,0 +1,44 @@
+package xltest
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const pieceLength = 256 << 10
+
+// encodeTorrent returns a multi-file .torrent for t, and its bencoded info
+// dictionary, whose SHA-1 is the infohash. Pieces are all zero.
+func encodeTorrent(announce string, t *Torrent) (file, info []byte) {
+	var b bytes.Buffer
+	b.WriteString("d5:filesl")
+	for i := range t.Files {
+		b.WriteString("d6:length")
+		fmt.Fprintf(&b, "i%de", t.Files[i].Size)
+		b.WriteString("4:pathl")
+		for _, p := range strings.Split(t.Files[i].Name, "/") {
+			writeString(&b, p)
+		}
+		b.WriteString("ee")
+	}
+	b.WriteString("e4:name")
+	writeString(&b, t.Name)
+	fmt.Fprintf(&b, "12:piece lengthi%de6:pieces", pieceLength)
+	pieces := int((t.size() + pieceLength - 1) / pieceLength)
+	writeString(&b, string(make([]byte, 20*pieces)))
+	b.WriteString("e")
+	info = b.Bytes()
+
+	var f bytes.Buffer
+	f.WriteString("d8:announce")
+	writeString(&f, announce)
+	f.WriteString("4:info")
+	f.Write(info)
+	f.WriteString("e")
+	return f.Bytes(), info
+}
+
+func writeString(b *bytes.Buffer, s string) {
+	fmt.Fprintf(b, "%d:%s", len(s), s)
+}
@@ -0,0 +1,946 @@
+// Package xltest runs a stand-in for the Xunlei login and lixian web services
+// on an httptest.Server, backed by an in-memory task store, so that package
+// protocol and its clients can be tested without network access or an account.
+//
+// A session is pointed at the server with
+//
+//	srv := xltest.NewServer()
+//	defer srv.Close()
+//	s, _ := protocol.NewSessionWithOptions(&protocol.Options{
+//		LoginURL:      srv.URL,
+//		LixianURL:     srv.URL,
+//		VerifyURLs:    []string{srv.VerifyURL()},
+//		CookieDomains: []string{srv.URL},
+//	})
+//	s.Login(xltest.Account, xltest.Password)
+package xltest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the account accepted by the server.
+const (
+	Account  = "xltest"
+	Password = "secret"
+	UserId   = "10000"
+	GdriveId = "C0FFEE0123456789ABCDEF0123456789"
+)
+
+const (
+	loginCode   = "!xlt"
+	timeLayout  = "2006-01-02 15:04:05"
+	expiredPage = `<script>top.location='http://cloud.vip.xunlei.com/task.html?error=1'</script>`
+)
+
+// Server is a fake Xunlei service. Its exported fields must be set before
+// requests are made.
+type Server struct {
+	*httptest.Server
+	// RequireCaptcha makes logins and bt submissions demand Captcha
+	// as image verification code.
+	RequireCaptcha bool
+	Captcha        string
+
+	mutex    sync.Mutex
+	latency  time.Duration
+	nextId   int
+	tasks    []*Task
+	classes  []*Class
+	torrents map[string]*Torrent
+	costs    map[string][2]int
+	reported map[string]bool
+	sessions map[string]bool
+	requests map[string]int
+	inflight map[string]int
+	peaks    map[string]int
+	failures map[string]*failure
+}
+
+type failure struct {
+	n      int
+	status int
+	body   string
+}
+
+type handler func(s *Server, w http.ResponseWriter, r *http.Request)
+
+// endpoints that do not need a session.
+var public = map[string]handler{
+	"check":     (*Server).check,
+	"sec2login": (*Server).sec2login,
+	"image":     (*Server).image,
+}
+
+var private = map[string]handler{
+	"login":             (*Server).login,
+	"verify_login":      (*Server).verifyLogin,
+	"user_task":         (*Server).userTask,
+	"user_history":      (*Server).userHistory,
+	"showtask_unfresh":  (*Server).showtaskUnfresh,
+	"task_check":        (*Server).taskCheck,
+	"task_commit":       (*Server).taskCommit,
+	"batch_task_commit": (*Server).batchTaskCommit,
+	"url_query":         (*Server).urlQuery,
+	"bt_task_commit":    (*Server).btTaskCommit,
+	"torrent_upload":    (*Server).torrentUpload,
+	"get_torrent":       (*Server).getTorrent,
+	"fill_bt_list":      (*Server).fillBtList,
+	"task_process":      (*Server).taskProcess,
+	"task_delete":       (*Server).taskDelete,
+	"task_pause":        (*Server).taskPause,
+	"task_delay":        (*Server).taskDelay,
+	"delay_once":        (*Server).delayOnce,
+	"redownload":        (*Server).redownload,
+	"rename":            (*Server).rename,
+	"menu_get":          (*Server).menuGet,
+	"menu_add":          (*Server).menuAdd,
+	"class_change":      (*Server).classChange,
+	"show_class":        (*Server).showClass,
+	"get_play_url":      (*Server).getPlayURL,
+	"batch_task_check":  (*Server).batchTaskCheck,
+}
+
+// NewServer starts a Server with an empty task store.
+func NewServer() *Server {
+	s := &Server{
+		nextId:   100000,
+		torrents: make(map[string]*Torrent),
+		costs:    make(map[string][2]int),
+		reported: make(map[string]bool),
+		sessions: make(map[string]bool),
+		requests: make(map[string]int),
+		inflight: make(map[string]int),
+		peaks:    make(map[string]int),
+		failures: make(map[string]*failure),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// VerifyURL is the verification image URL to use in protocol.Options.
+func (s *Server) VerifyURL() string {
+	return s.URL + "/image?cachetime=%d"
+}
+
+// Expire invalidates all sessions, as if their cookies had timed out.
+func (s *Server) Expire() {
+	s.mutex.Lock()
+	s.sessions = make(map[string]bool)
+	s.mutex.Unlock()
+}
+
+// FailNext makes the next n requests to endpoint, e.g. "task_commit",
+// fail with the given HTTP status.
+func (s *Server) FailNext(endpoint string, n, status int) {
+	s.mutex.Lock()
+	s.failures[endpoint] = &failure{n: n, status: status}
+	s.mutex.Unlock()
+}
+
+// ReplyNext makes the next n requests to endpoint reply with body instead,
+// e.g. to serve a reply the real service may send but the Server does not.
+func (s *Server) ReplyNext(endpoint string, n int, body string) {
+	s.mutex.Lock()
+	s.failures[endpoint] = &failure{n: n, status: http.StatusOK, body: body}
+	s.mutex.Unlock()
+}
+
+// Requests returns how many requests endpoint has received.
+func (s *Server) Requests(endpoint string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[endpoint]
+}
+
+// SetLatency delays every reply by d, so that concurrent requests overlap.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mutex.Lock()
+	s.latency = d
+	s.mutex.Unlock()
+}
+
+// Peak returns the most requests endpoint has been handling at once.
+func (s *Server) Peak(endpoint string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.peaks[endpoint]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.Trim(strings.TrimPrefix(r.URL.Path, "/interface/"), "/")
+	s.mutex.Lock()
+	s.requests[endpoint]++
+	if s.inflight[endpoint]++; s.inflight[endpoint] > s.peaks[endpoint] {
+		s.peaks[endpoint] = s.inflight[endpoint]
+	}
+	latency := s.latency
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		s.inflight[endpoint]--
+		s.mutex.Unlock()
+	}()
+	time.Sleep(latency)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if f := s.failures[endpoint]; f != nil && f.n > 0 {
+		f.n--
+		w.WriteHeader(f.status)
+		io.WriteString(w, f.body)
+		return
+	}
+	if h, ok := public[endpoint]; ok {
+		h(s, w, r)
+	} else if h, ok = private[endpoint]; !ok {
+		http.NotFound(w, r)
+	} else if c, err := r.Cookie("sessionid"); err != nil || !s.sessions[c.Value] {
+		io.WriteString(w, expiredPage)
+	} else {
+		h(s, w, r)
+	}
+}
+
+func (s *Server) check(w http.ResponseWriter, r *http.Request) {
+	result := "0:" + loginCode
+	if s.RequireCaptcha {
+		result = "1:"
+	}
+	setCookie(w, "check_result", result)
+}
+
+func (s *Server) sec2login(w http.ResponseWriter, r *http.Request) {
+	vcode := strings.ToUpper(loginCode)
+	if s.RequireCaptcha {
+		vcode = strings.ToUpper(s.Captcha)
+	}
+	var result string
+	switch {
+	case r.FormValue("verifycode") != vcode:
+		result = "1"
+	case r.FormValue("u") != Account || r.FormValue("p") != md5hex(md5hex(md5hex(Password))+vcode):
+		result = "4"
+	default:
+		sid := randomHex(16)
+		s.sessions[sid] = true
+		setCookie(w, "userid", UserId)
+		setCookie(w, "sessionid", sid)
+		setCookie(w, "blogresult", "0")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: "userid", Path: "/", MaxAge: -1})
+	setCookie(w, "blogresult", result)
+}
+
+func (s *Server) image(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, image.NewGray(image.Rect(0, 0, 4, 2)))
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "<html><head><title>lixian</title></head><body>%s</body></html>", strings.Repeat("<!-- xltest -->\n", 40))
+}
+
+func (s *Server) verifyLogin(w http.ResponseWriter, r *http.Request) {
+	writeJSONP(w, r.FormValue("callback"), map[string]interface{}{
+		"result": 1,
+		"data": map[string]string{
+			"userid":    UserId,
+			"usrname":   Account,
+			"usernewno": UserId,
+			"usrtype":   "3",
+			"nickname":  Account,
+			"vipstate":  "6",
+		},
+	})
+}
+
+func (s *Server) userTask(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "<html><body>user_task</body></html>")
+}
+
+func (s *Server) showtaskUnfresh(w http.ResponseWriter, r *http.Request) {
+	typeId := r.FormValue("type_id")
+	ts := s.list(func(t *Task) bool {
+		switch typeId {
+		case "1":
+			return t.Flag == FlagNormal && t.Status != StatusCompleted
+		case "2":
+			return t.Flag == FlagNormal && t.Status == StatusCompleted
+		}
+		return t.Flag == FlagNormal || t.Flag == FlagExpired
+	})
+	total := len(ts)
+	ts = paginate(ts, atoi(r.FormValue("page"), 1), atoi(r.FormValue("tasknum"), 100))
+	tasks := make([]map[string]interface{}, len(ts))
+	for i := range ts {
+		tasks[i] = s.lixianTask(ts[i])
+	}
+	b, _ := json.Marshal(map[string]interface{}{
+		"rtcode": 0,
+		"info": map[string]interface{}{
+			"tasks": tasks,
+			"user": map[string]string{
+				"cookie":          GdriveId,
+				"total_num":       strconv.Itoa(total),
+				"max_task_num":    "2000",
+				"available_space": "1099511627776",
+				"max_store":       "1099511627776",
+				"vip_level":       "6",
+				"user_type":       "3",
+				"goldbean_num":    "0",
+				"silverbean_num":  "0",
+			},
+			"show_arc":  0,
+			"total_num": strconv.Itoa(total),
+		},
+		"userinfo": map[string]interface{}{
+			"all_space":        "1099511627776",
+			"all_used_store":   s.usedSpace(),
+			"all_space_format": "1T",
+		},
+	})
+	fmt.Fprintf(w, "rebuild(%s)", b)
+}
+
+func (s *Server) lixianTask(t *Task) map[string]interface{} {
+	userid, _ := strconv.Atoi(UserId)
+	return map[string]interface{}{
+		"id":              t.Id,
+		"flag":            strconv.Itoa(t.Flag),
+		"cid":             t.Cid,
+		"gcid":            t.GCid,
+		"taskname":        t.Name,
+		"url":             t.URL,
+		"download_status": strconv.Itoa(t.Status),
+		"speed":           t.Speed,
+		"progress":        t.Progress,
+		"filesize":        formatSize(t.Size),
+		"ysfilesize":      strconv.FormatInt(t.Size, 10),
+		"tasktype":        t.Type,
+		"lixian_url":      t.LixianURL,
+		"class_value":     strconv.Itoa(t.Class),
+		"dt_committed":    t.Committed.Format(timeLayout),
+		"left_live_time":  "30",
+		"userid":          userid,
+		"user_type":       "3",
+	}
+}
+
+func (s *Server) usedSpace() (n int64) {
+	for _, t := range s.tasks {
+		if t.Flag == FlagNormal {
+			n += t.Size
+		}
+	}
+	return
+}
+
+func (s *Server) userHistory(w http.ResponseWriter, r *http.Request) {
+	flag := FlagDeleted
+	if r.FormValue("type") == "1" {
+		flag = FlagExpired
+	}
+	ts := s.list(func(t *Task) bool { return t.Flag == flag })
+	size := 100
+	if c, err := r.Cookie("pagenum"); err == nil {
+		size = atoi(c.Value, size)
+	}
+	page := atoi(r.FormValue("p"), 1)
+	more := page*size < len(ts)
+	ts = paginate(ts, page, size)
+	var b bytes.Buffer
+	b.WriteString("<html><head><title>history</title></head><body>\n<div class=\"rwbox\" id=\"rowbox_list\">\n")
+	for _, t := range ts {
+		fmt.Fprintf(&b, "<div class=\"rw_list\" id=\"tr_c%s\" taskid=\"%s\">\n", t.Id, t.Id)
+		for _, input := range [][2]string{
+			{"d_status", strconv.Itoa(t.Status)},
+			{"dflag", strconv.Itoa(t.Flag)},
+			{"dcid", t.Cid},
+			{"f_url", t.URL},
+			{"taskname", t.Name},
+			{"d_tasktype", strconv.Itoa(t.Type)},
+			{"ysfilesize", strconv.FormatInt(t.Size, 10)},
+			{"dl_url", t.LixianURL},
+		} {
+			fmt.Fprintf(&b, "<input id=\"%s%s\" type=\"hidden\" value=\"%s\" />\n", input[0], t.Id, html.EscapeString(input[1]))
+		}
+		fmt.Fprintf(&b, "<span class=\"c_addtime\">%s</span>\n", t.Committed.Format(timeLayout))
+		fmt.Fprintf(&b, "<em class=\"loadnum\">%.0f%%</em>\n", t.Progress)
+		b.WriteString("</div>\n")
+	}
+	b.WriteString("</div>\n<div class=\"page\"><ul>\n")
+	if more {
+		fmt.Fprintf(&b, "<li class=\"next\"><a href=\"/user_history?userid=%s&amp;p=%d\">下一页</a></li>\n", UserId, page+1)
+	}
+	b.WriteString("</ul></div>\n</body></html>\n")
+	w.Write(b.Bytes())
+}
+
+func (s *Server) taskCheck(w http.ResponseWriter, r *http.Request) {
+	u := r.FormValue("url")
+	cost := s.costs[u]
+	fmt.Fprintf(w, "%s('%X','%X','%d','%d','%s','%d','%d','0','%s','0')", r.FormValue("callback"),
+		md5.Sum([]byte(u)), md5.Sum([]byte("gcid:"+u)), 1<<20, 1<<40, jsQuote(nameOf(u)), cost[0], cost[1], randomHex(8))
+}
+
+func (s *Server) batchTaskCheck(w http.ResponseWriter, r *http.Request) {
+	var data []map[string]interface{}
+	for i, u := range strings.Split(r.FormValue("url"), "\r\n") {
+		u = strings.TrimSpace(u)
+		if !strings.Contains(u, "://") {
+			continue
+		}
+		cost := s.costs[u]
+		cid := fmt.Sprintf("%X", md5.Sum([]byte(u)))
+		exist := "0"
+		for _, t := range s.tasks {
+			if t.Flag == FlagNormal && (t.URL == u || t.Cid == cid) {
+				exist = "1"
+			}
+		}
+		data = append(data, map[string]interface{}{
+			"id":         i,
+			"url":        u,
+			"cid":        cid,
+			"gcid":       fmt.Sprintf("%X", md5.Sum([]byte("gcid:"+u))),
+			"filesize":   strconv.Itoa(1 << 20),
+			"filename":   nameOf(u),
+			"goldbean":   strconv.Itoa(cost[0]),
+			"silverbean": strconv.Itoa(cost[1]),
+			"exist":      exist,
+		})
+	}
+	b, _ := json.Marshal(map[string]interface{}{"rtcode": 0, "data": data})
+	fmt.Fprintf(w, "<script>document.domain=\"xunlei.com\";parent.%s(%s)</script>", r.FormValue("callback"), b)
+}
+
+func (s *Server) taskCommit(w http.ResponseWriter, r *http.Request) {
+	var id string
+	if t := s.task(r.FormValue("o_taskid")); t != nil {
+		t.Flag, t.Status, id = FlagNormal, StatusWaiting, t.Id
+	} else {
+		ty := TypeOrdinary
+		if r.FormValue("type") == "2" {
+			ty = TypeEd2k
+		}
+		size, _ := strconv.ParseInt(r.FormValue("size"), 10, 64)
+		id = s.addTask(&Task{
+			Name:  r.FormValue("t"),
+			URL:   r.FormValue("url"),
+			Cid:   r.FormValue("cid"),
+			GCid:  r.FormValue("gcid"),
+			Size:  size,
+			Type:  ty,
+			Class: s.classOf(r),
+		})
+	}
+	fmt.Fprintf(w, "%s(1,'%s','0.5')", r.FormValue("callback"), id)
+}
+
+func (s *Server) batchTaskCommit(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	var list []map[string]interface{}
+	if r.FormValue("interfrom") == "history" {
+		for _, id := range strings.Split(r.FormValue("batch_old_taskid"), ",") {
+			if t := s.task(id); t != nil {
+				t.Flag, t.Status = FlagNormal, StatusWaiting
+				list = append(list, map[string]interface{}{"url": t.URL, "id": t.Id, "result": 1})
+			}
+		}
+		writeJSONP(w, r.FormValue("callback"), map[string]interface{}{"progress": 1, "rtcode": 0, "list": list})
+		return
+	}
+	for _, u := range r.PostForm["url[]"] {
+		if unescaped, err := url.QueryUnescape(u); err == nil {
+			u = unescaped
+		}
+		item := map[string]interface{}{"url": u, "id": "", "result": 1}
+		switch cost := s.costs[u]; {
+		case !strings.Contains(u, "://"):
+			item["result"], item["msg"] = 0, "无效的链接"
+		case cost[0] > 0 || cost[1] > 0:
+			item["result"], item["msg"] = 0, "该资源需要消耗金豆或银豆"
+		case s.taskByURL(u) != nil:
+			item["result"], item["msg"] = -1, "任务已存在"
+		default:
+			item["id"] = s.addTask(&Task{Name: nameOf(u), URL: u, Size: 1 << 20, Type: TypeOrdinary})
+		}
+		list = append(list, item)
+	}
+	writeJSONP(w, r.FormValue("callback"), map[string]interface{}{"progress": 1, "rtcode": 0, "list": list})
+}
+
+func (s *Server) urlQuery(w http.ResponseWriter, r *http.Request) {
+	u := r.FormValue("u")
+	var hash string
+	if strings.HasPrefix(u, "magnet:") {
+		if m, err := url.Parse(u); err == nil {
+			hash = strings.TrimPrefix(m.Query().Get("xt"), "urn:btih:")
+		}
+	} else if m, err := url.Parse(u); err == nil {
+		hash = m.Query().Get("infoid")
+	}
+	hash = strings.ToUpper(hash)
+	t, ok := s.torrents[hash]
+	if !ok {
+		fmt.Fprintf(w, "%s(0,'')", r.FormValue("callback"))
+		return
+	}
+	if s.taskByCid(hash) != nil {
+		fmt.Fprintf(w, "%s(-1,'%s')", r.FormValue("callback"), hash)
+		return
+	}
+	var names, sizesf, sizes, picked, exts, index, valid []string
+	for i, f := range t.Files {
+		names = append(names, jsQuote(f.Name))
+		sizesf = append(sizesf, formatSize(f.Size))
+		sizes = append(sizes, strconv.FormatInt(f.Size, 10))
+		picked = append(picked, "1")
+		exts = append(exts, strings.TrimPrefix(path.Ext(f.Name), "."))
+		index = append(index, strconv.Itoa(i))
+		valid = append(valid, "1")
+	}
+	fmt.Fprintf(w, "%s(1,'%s','%d','%s','0',%s,%s,%s,%s,%s,%s,%s,'%d.5','0')", r.FormValue("callback"),
+		hash, t.size(), jsQuote(t.Name), jsArray(names), jsArray(sizesf), jsArray(sizes),
+		jsArray(picked), jsArray(exts), jsArray(index), jsArray(valid), s.nextId)
+}
+
+func (s *Server) btTaskCommit(w http.ResponseWriter, r *http.Request) {
+	callback := r.FormValue("callback")
+	if s.RequireCaptcha && !strings.EqualFold(r.FormValue("verify_code"), s.Captcha) {
+		writeJSONP(w, callback, map[string]interface{}{"progress": -12})
+		return
+	}
+	hash := strings.ToUpper(r.FormValue("cid"))
+	torrent, ok := s.torrents[hash]
+	switch {
+	case s.reported[hash]:
+		writeJSONP(w, callback, map[string]interface{}{
+			"id": "", "avail_space": nil, "progress": 2, "time": 0.1,
+			"rtcode": "75", "msg": "该资源被举报，无法添加到离线空间[0975]",
+		})
+		return
+	case !ok:
+		writeJSONP(w, callback, map[string]interface{}{"progress": 2, "rtcode": "-1", "msg": "种子信息获取失败"})
+		return
+	}
+	if t := s.task(r.FormValue("o_taskid")); t != nil {
+		t.Flag, t.Status = FlagNormal, StatusWaiting
+		writeJSONP(w, callback, map[string]interface{}{"id": t.Id, "avail_space": "1", "time": 0.5, "progress": 1})
+		return
+	}
+	if s.taskByCid(hash) != nil {
+		writeJSONP(w, callback, map[string]interface{}{"progress": 2, "rtcode": "0", "msg": "任务已存在"})
+		return
+	}
+	t := &Task{Name: r.FormValue("btname"), URL: "bt://" + hash, Cid: hash, Type: TypeBt, Class: s.classOf(r)}
+	if t.Name == "" {
+		t.Name = torrent.Name
+	}
+	for _, i := range strings.Split(r.FormValue("findex"), "_") {
+		if n, err := strconv.Atoi(i); err == nil && n >= 0 && n < len(torrent.Files) {
+			f := torrent.Files[n]
+			f.Status = StatusWaiting
+			t.Files = append(t.Files, f)
+			t.Size += f.Size
+		}
+	}
+	id := s.addTask(t)
+	writeJSONP(w, callback, map[string]interface{}{"id": id, "avail_space": "1", "time": 0.5, "progress": 1})
+}
+
+func (s *Server) torrentUpload(w http.ResponseWriter, r *http.Request) {
+	f, _, err := r.FormFile("filepath")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, _ := ioutil.ReadAll(f)
+	f.Close()
+	var torrent *Torrent
+	for _, t := range s.torrents {
+		if bytes.Equal(t.file, b) {
+			torrent = t
+			break
+		}
+	}
+	if torrent == nil {
+		io.WriteString(w, `<script>document.domain="xunlei.com";alert('种子文件无效');</script>`)
+		return
+	}
+	if s.taskByCid(torrent.InfoHash) != nil {
+		fmt.Fprintf(w, `<script>document.domain="xunlei.com";parent.edit_bt_list({"infoid":"%s"},'');</script>`, torrent.InfoHash)
+		return
+	}
+	files := make([]map[string]interface{}, len(torrent.Files))
+	for i, f := range torrent.Files {
+		files[i] = map[string]interface{}{
+			"id":            strconv.Itoa(i),
+			"subsize":       strconv.FormatInt(f.Size, 10),
+			"subformatsize": formatSize(f.Size),
+			"valid":         1,
+			"findex":        strconv.Itoa(i),
+			"subtitle":      f.Name,
+			"ext":           strings.TrimPrefix(path.Ext(f.Name), "."),
+		}
+	}
+	result, _ := json.Marshal(map[string]interface{}{
+		"ret_value": 1,
+		"infoid":    torrent.InfoHash,
+		"ftitle":    torrent.Name,
+		"btsize":    torrent.size(),
+		"is_full":   "0",
+		"filelist":  files,
+		"random":    randomHex(8),
+	})
+	fmt.Fprintf(w, `<script>document.domain="xunlei.com";var btResult =%s;var btRtcode = 0</script>`, result)
+}
+
+func (s *Server) getTorrent(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.torrents[strings.ToUpper(r.FormValue("infoid"))]
+	if !ok {
+		io.WriteString(w, `<script>alert('对不起，没有找到对应的种子文件!');</script>`)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Write(t.file)
+}
+
+func (s *Server) fillBtList(w http.ResponseWriter, r *http.Request) {
+	t := s.task(r.FormValue("tid"))
+	if t == nil || t.Type != TypeBt {
+		io.WriteString(w, `<script>alert('任务不存在');</script>`)
+		return
+	}
+	size := 999
+	if c, err := r.Cookie("pagenum"); err == nil {
+		size = atoi(c.Value, size)
+	}
+	page := atoi(r.FormValue("p"), 1)
+	var records []map[string]interface{}
+	for i := (page - 1) * size; i >= 0 && i < len(t.Files) && i < page*size; i++ {
+		f := t.Files[i]
+		record := map[string]interface{}{
+			"id":              i,
+			"title":           f.Name,
+			"download_status": strconv.Itoa(f.Status),
+			"cid":             f.Cid,
+			"size":            formatSize(f.Size),
+			"percent":         0,
+			"taskid":          t.Id,
+			"livetime":        "30",
+			"downurl":         "",
+			"filesize":        strconv.FormatInt(f.Size, 10),
+			"verify":          "",
+			"url":             fmt.Sprintf("bt://%s/%d", t.Cid, i),
+			"dirtitle":        path.Dir(f.Name),
+		}
+		if f.Status == StatusCompleted {
+			record["percent"] = 100
+			record["downurl"] = fmt.Sprintf("%s/download/%s/%d", s.URL, t.Id, i)
+		}
+		records = append(records, record)
+	}
+	writeJSONP(w, r.FormValue("callback"), map[string]interface{}{"Result": map[string]interface{}{
+		"Tid":      t.Id,
+		"Infoid":   t.Cid,
+		"btnum":    strconv.Itoa(len(t.Files)),
+		"btpernum": size,
+		"now_page": page,
+		"Record":   records,
+	}})
+}
+
+func (s *Server) taskProcess(w http.ResponseWriter, r *http.Request) {
+	records := []map[string]interface{}{}
+	var downloading, waiting int
+	for _, id := range strings.Split(r.FormValue("list"), ",") {
+		t := s.task(id)
+		if t == nil {
+			continue
+		}
+		switch t.Status {
+		case StatusDownloading:
+			downloading++
+		case StatusWaiting:
+			waiting++
+		}
+		records = append(records, map[string]interface{}{
+			"tid":             t.Id,
+			"url":             t.URL,
+			"speed":           t.Speed,
+			"fpercent":        t.Progress,
+			"leave_time":      "0",
+			"fsize":           formatSize(t.Size),
+			"download_status": strconv.Itoa(t.Status),
+			"lixian_url":      t.LixianURL,
+			"left_live_time":  "30",
+			"tasktype":        strconv.Itoa(t.Type),
+			"filesize":        strconv.FormatInt(t.Size, 10),
+		})
+	}
+	writeJSONP(w, r.FormValue("callback"), map[string]interface{}{"Process": map[string]interface{}{
+		"Record": records,
+		"Task": map[string]string{
+			"downloading_num": strconv.Itoa(downloading),
+			"waiting_num":     strconv.Itoa(waiting),
+		},
+	}})
+}
+
+func (s *Server) taskDelete(w http.ResponseWriter, r *http.Request) {
+	ty := atoi(r.URL.Query().Get("type"), 0)
+	for _, id := range strings.Split(r.FormValue("taskids"), ",") {
+		if t := s.task(id); t != nil {
+			if ty == FlagDeleted {
+				t.Flag = FlagPurged
+			} else {
+				t.Flag = FlagDeleted
+			}
+		}
+	}
+	writeJSONP(w, r.URL.Query().Get("callback"), map[string]interface{}{"result": 1, "type": ty})
+}
+
+func (s *Server) taskPause(w http.ResponseWriter, r *http.Request) {
+	for _, id := range strings.Split(r.FormValue("tid"), ",") {
+		if t := s.task(id); t != nil && t.Status != StatusCompleted {
+			t.Status = StatusPending
+		}
+	}
+	io.WriteString(w, "pause_task_resp()")
+}
+
+func (s *Server) taskDelay(w http.ResponseWriter, r *http.Request) {
+	var ids []map[string]string
+	for _, id := range strings.Split(r.FormValue("taskids"), ",") {
+		if id = strings.TrimSuffix(id, "_1"); s.task(id) != nil {
+			ids = append(ids, map[string]string{"id": id})
+		}
+	}
+	result, _ := json.Marshal(map[string]interface{}{"result": 1, "0": map[string]string{"left_live_time": "30"}})
+	list, _ := json.Marshal(ids)
+	fmt.Fprintf(w, "task_delay_resp(%s,%s)", result, list)
+}
+
+func (s *Server) delayOnce(w http.ResponseWriter, r *http.Request) {
+	writeJSONP(w, r.FormValue("callback"), map[string]interface{}{"result": 1})
+}
+
+func (s *Server) redownload(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	for _, id := range r.PostForm["id[]"] {
+		if t := s.task(id); t != nil {
+			t.Flag, t.Status = FlagNormal, StatusWaiting
+		}
+	}
+	writeJSONP(w, r.URL.Query().Get("callback"), map[string]interface{}{"result": 1})
+}
+
+func (s *Server) rename(w http.ResponseWriter, r *http.Request) {
+	t := s.task(r.FormValue("taskid"))
+	if t == nil {
+		writeJSONP(w, "", map[string]interface{}{"result": 1})
+		return
+	}
+	t.Name = r.FormValue("filename")
+	id, _ := strconv.Atoi(t.Id)
+	writeJSONP(w, "", map[string]interface{}{"result": 0, "taskid": id, "filename": t.Name})
+}
+
+// classOf returns the class_id of a submission, if it is a known category.
+func (s *Server) classOf(r *http.Request) int {
+	id := atoi(r.FormValue("class_id"), 0)
+	if s.class(id) == nil {
+		return 0
+	}
+	return id
+}
+
+func (s *Server) menuGet(w http.ResponseWriter, r *http.Request) {
+	info := make([]map[string]string, len(s.classes))
+	for i, c := range s.classes {
+		n := len(s.list(func(t *Task) bool { return t.Flag == FlagNormal && t.Class == c.Id }))
+		info[i] = map[string]string{"id": strconv.Itoa(c.Id), "name": c.Name, "num": strconv.Itoa(n)}
+	}
+	writeJSONP(w, r.FormValue("callback"), map[string]interface{}{"rtcode": 0, "info": info})
+}
+
+func (s *Server) menuAdd(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		writeJSONP(w, r.FormValue("callback"), map[string]interface{}{"rtcode": 1, "msg": "分类名称不能为空"})
+		return
+	}
+	writeJSONP(w, r.FormValue("callback"), map[string]interface{}{"rtcode": 0, "id": strconv.Itoa(s.addClass(name))})
+}
+
+func (s *Server) classChange(w http.ResponseWriter, r *http.Request) {
+	id := atoi(r.FormValue("class_id"), -1)
+	if id != 0 && s.class(id) == nil {
+		writeJSONP(w, r.FormValue("callback"), map[string]interface{}{"rtcode": 2, "msg": "分类不存在"})
+		return
+	}
+	for _, tid := range strings.Split(r.FormValue("taskids"), ",") {
+		if t := s.task(tid); t != nil {
+			t.Class = id
+		}
+	}
+	writeJSONP(w, r.FormValue("callback"), map[string]interface{}{"rtcode": 0})
+}
+
+func (s *Server) showClass(w http.ResponseWriter, r *http.Request) {
+	id := atoi(r.FormValue("type_id"), 0)
+	ts := s.list(func(t *Task) bool { return t.Flag == FlagNormal && t.Class == id })
+	tasks := make([]map[string]interface{}, len(ts))
+	for i := range ts {
+		tasks[i] = s.lixianTask(ts[i])
+	}
+	writeJSONP(w, r.FormValue("callback"), map[string]interface{}{
+		"rtcode": 0,
+		"info": map[string]interface{}{
+			"tasks": tasks,
+			"user":  map[string]string{"total_num": strconv.Itoa(len(ts))},
+		},
+	})
+}
+
+// playable returns the id of the task, or the task id and file index of the
+// bt file, named by the url of a get_play_url request, and whether it is
+// completed.
+func (s *Server) playable(u string) (id string, ok bool) {
+	if strings.HasPrefix(u, "bt://") {
+		parts := strings.SplitN(strings.TrimPrefix(u, "bt://"), "/", 2)
+		if len(parts) != 2 {
+			return "", false
+		}
+		t, i := s.taskByCid(parts[0]), atoi(parts[1], -1)
+		if t == nil || i < 0 || i >= len(t.Files) {
+			return "", false
+		}
+		return t.Id + "/" + parts[1], t.Files[i].Status == StatusCompleted
+	}
+	if t := s.taskByURL(u); t != nil {
+		return t.Id, t.Status == StatusCompleted
+	}
+	return "", false
+}
+
+func (s *Server) getPlayURL(w http.ResponseWriter, r *http.Request) {
+	u := r.FormValue("url")
+	id, ok := s.playable(u)
+	if !ok {
+		writeJSONP(w, r.FormValue("callback"), map[string]interface{}{"rtcode": 1, "msg": "文件不存在或尚未下载完成"})
+		return
+	}
+	var vods []map[string]string
+	for _, spec := range []struct{ id, width, height string }{
+		{"225536", "640", "360"},
+		{"226048", "1280", "720"},
+	} {
+		vods = append(vods, map[string]string{
+			"spec_id": spec.id,
+			"width":   spec.width,
+			"height":  spec.height,
+			"vod_url": fmt.Sprintf("%s/vod/%s?spec_id=%s", s.URL, id, spec.id),
+		})
+	}
+	writeJSONP(w, r.FormValue("callback"), map[string]interface{}{
+		"rtcode": 0,
+		"info":   map[string]interface{}{"cookie": "vod=" + md5hex(u), "vod_list": vods},
+	})
+}
+
+func writeJSONP(w http.ResponseWriter, callback string, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "%s(%s)", callback, b)
+}
+
+func setCookie(w http.ResponseWriter, name, value string) {
+	http.SetCookie(w, &http.Cookie{Name: name, Value: value, Path: "/", MaxAge: 86400})
+}
+
+func paginate(ts []*Task, page, size int) []*Task {
+	if page < 1 {
+		page = 1
+	}
+	start, end := (page-1)*size, page*size
+	if start > len(ts) {
+		start = len(ts)
+	}
+	if end > len(ts) {
+		end = len(ts)
+	}
+	return ts[start:end]
+}
+
+func atoi(s string, def int) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	return def
+}
+
+// nameOf returns the file name of a link, as task_check would.
+func nameOf(u string) string {
+	if strings.HasPrefix(u, "ed2k://") {
+		if parts := strings.Split(u, "|"); len(parts) > 2 {
+			return parts[2]
+		}
+	}
+	if m, err := url.Parse(u); err == nil && m.Path != "" {
+		return path.Base(m.Path)
+	}
+	return path.Base(u)
+}
+
+func jsQuote(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+func jsArray(items []string) string {
+	return "new Array('" + strings.Join(items, "','") + "')"
+}
+
+func formatSize(n int64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return strconv.FormatFloat(f, 'f', 1, 64) + units[i]
+}
+
+func md5hex(s string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(s)))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
@@ -0,0 +1,242 @@
+package xltest
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// task types, as reported in the tasktype field.
+const (
+	TypeBt       = 0
+	TypeOrdinary = 1
+	TypeEd2k     = 2
+)
+
+// download statuses.
+const (
+	StatusWaiting     = 0
+	StatusDownloading = 1
+	StatusCompleted   = 2
+	StatusFailed      = 3
+	StatusPending     = 5
+)
+
+// task flags.
+const (
+	FlagNormal  = 0
+	FlagDeleted = 1
+	FlagPurged  = 2
+	FlagExpired = 4
+)
+
+// Task is a task kept by the Server.
+type Task struct {
+	Id        string
+	Name      string
+	URL       string
+	Cid       string // infohash for bt tasks
+	GCid      string
+	Size      int64
+	Type      int
+	Status    int
+	Flag      int
+	Class     int // category id, 0 for none
+	Progress  float32
+	Speed     string
+	LixianURL string
+	Committed time.Time
+	Files     []File // bt tasks only
+}
+
+// File is a file of a torrent or of a bt task.
+type File struct {
+	Name   string
+	Size   int64
+	Status int
+	Cid    string
+}
+
+// Class is a task category.
+type Class struct {
+	Id   int
+	Name string
+}
+
+// Torrent is a torrent known to the Server, so that it can be queried by
+// magnet link or infohash and uploaded as the file returned by TorrentFile.
+type Torrent struct {
+	InfoHash string
+	Name     string
+	Files    []File
+	file     []byte
+}
+
+func (t *Torrent) size() (n int64) {
+	for i := range t.Files {
+		n += t.Files[i].Size
+	}
+	return
+}
+
+func (t *Task) clone() *Task {
+	c := *t
+	c.Files = append([]File(nil), t.Files...)
+	return &c
+}
+
+// AddTask adds a copy of t to the store, newest first, and returns its id;
+// an empty Id is assigned one.
+func (s *Server) AddTask(t Task) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.addTask(t.clone())
+}
+
+func (s *Server) addTask(t *Task) string {
+	if t.Id == "" {
+		s.nextId++
+		t.Id = strconv.Itoa(s.nextId)
+	}
+	if t.Cid == "" {
+		t.Cid = fmt.Sprintf("%X", sha1.Sum([]byte(t.URL)))
+	}
+	if t.LixianURL == "" && t.Type != TypeBt {
+		t.LixianURL = s.URL + "/download/" + t.Id
+	}
+	if t.Committed.IsZero() {
+		t.Committed = time.Now()
+	}
+	s.tasks = append(s.tasks, t)
+	return t.Id
+}
+
+// Tasks returns copies of all tasks, newest first, purged ones included.
+func (s *Server) Tasks() []Task {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ts := make([]Task, 0, len(s.tasks))
+	for i := len(s.tasks) - 1; i >= 0; i-- {
+		ts = append(ts, *s.tasks[i].clone())
+	}
+	return ts
+}
+
+// Task returns a copy of the task with the given id.
+func (s *Server) Task(id string) (Task, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if t := s.task(id); t != nil {
+		return *t.clone(), true
+	}
+	return Task{}, false
+}
+
+// UpdateTask applies fn to the task with the given id.
+func (s *Server) UpdateTask(id string, fn func(t *Task)) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t := s.task(id)
+	if t != nil {
+		fn(t)
+	}
+	return t != nil
+}
+
+func (s *Server) task(id string) *Task {
+	for _, t := range s.tasks {
+		if t.Id == id {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *Server) taskByCid(cid string) *Task {
+	for _, t := range s.tasks {
+		if strings.EqualFold(t.Cid, cid) && t.Flag == FlagNormal {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *Server) taskByURL(u string) *Task {
+	for _, t := range s.tasks {
+		if t.URL == u && t.Flag == FlagNormal {
+			return t
+		}
+	}
+	return nil
+}
+
+// list returns the tasks matching keep, newest first.
+func (s *Server) list(keep func(t *Task) bool) []*Task {
+	var ts []*Task
+	for i := len(s.tasks) - 1; i >= 0; i-- {
+		if keep(s.tasks[i]) {
+			ts = append(ts, s.tasks[i])
+		}
+	}
+	return ts
+}
+
+// AddClass adds a task category and returns its id.
+func (s *Server) AddClass(name string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.addClass(name)
+}
+
+func (s *Server) addClass(name string) int {
+	id := len(s.classes) + 1
+	s.classes = append(s.classes, &Class{Id: id, Name: name})
+	return id
+}
+
+func (s *Server) class(id int) *Class {
+	for _, c := range s.classes {
+		if c.Id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// AddTorrent registers a torrent and returns its infohash.
+func (s *Server) AddTorrent(name string, files ...File) string {
+	t := &Torrent{Name: name, Files: append([]File(nil), files...)}
+	var info []byte
+	t.file, info = encodeTorrent(s.URL+"/announce", t)
+	t.InfoHash = fmt.Sprintf("%X", sha1.Sum(info))
+	s.mutex.Lock()
+	s.torrents[t.InfoHash] = t
+	s.mutex.Unlock()
+	return t.InfoHash
+}
+
+// TorrentFile returns the .torrent file of a registered torrent.
+func (s *Server) TorrentFile(infohash string) []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if t, ok := s.torrents[strings.ToUpper(infohash)]; ok {
+		return t.file
+	}
+	return nil
+}
+
+// SetCost makes tasks for url cost the given gold and silver beans.
+func (s *Server) SetCost(url string, gold, silver int) {
+	s.mutex.Lock()
+	s.costs[url] = [2]int{gold, silver}
+	s.mutex.Unlock()
+}
+
+// Report makes the torrent with infohash be refused as reported content.
+func (s *Server) Report(infohash string) {
+	s.mutex.Lock()
+	s.reported[strings.ToUpper(infohash)] = true
+	s.mutex.Unlock()
+}
@@ -0,0 +1,231 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zyxar/xunlei/protocol/xltest"
+)
+
+func newTestSession(t *testing.T) (*session, *xltest.Server) {
+	srv := xltest.NewServer()
+	s, err := newSessionWithOptions(&Options{
+		LoginURL:      srv.URL,
+		LixianURL:     srv.URL,
+		VerifyURLs:    []string{srv.VerifyURL()},
+		CookieDomains: []string{srv.URL},
+	})
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	if _, err = s.Login(xltest.Account, EncryptPass(xltest.Password)); err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return s, srv
+}
+
+func TestFakeServerCaptcha(t *testing.T) {
+	srv := xltest.NewServer()
+	defer srv.Close()
+	srv.RequireCaptcha, srv.Captcha = true, "abcd"
+	s, err := newSessionWithOptions(&Options{
+		LoginURL:      srv.URL,
+		LixianURL:     srv.URL,
+		VerifyURLs:    []string{srv.VerifyURL()},
+		CookieDomains: []string{srv.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	solver := CaptchaSolverFunc(func(ctx context.Context, image []byte) (string, error) {
+		return "abcd\n", nil
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			s.SetCaptchaSolver(solver)
+		}
+	}()
+	s.SetCaptchaSolver(solver)
+	if _, err = s.Login(xltest.Account, EncryptPass(xltest.Password)); err != nil {
+		t.Error(err)
+	}
+	<-done
+}
+
+func findTask(ts []*Task, name string) *Task {
+	for i := range ts {
+		if ts[i].TaskName == name {
+			return ts[i]
+		}
+	}
+	return nil
+}
+
+func TestFakeServerLogin(t *testing.T) {
+	srv := xltest.NewServer()
+	defer srv.Close()
+	s, err := newSessionWithOptions(&Options{
+		LoginURL:      srv.URL,
+		LixianURL:     srv.URL,
+		VerifyURLs:    []string{srv.VerifyURL()},
+		CookieDomains: []string{srv.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Login(xltest.Account, EncryptPass("wrong")); !errors.Is(err, ErrLoginFailed) {
+		t.Errorf("expected ErrLoginFailed, got %v", err)
+	}
+	result, err := s.Login(xltest.Account, EncryptPass(xltest.Password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.UserId != xltest.UserId {
+		t.Errorf("unexpected user id: %s", result.UserId)
+	}
+	if !s.IsOn() {
+		t.Error("expected session to be on")
+	}
+	if gid, err := s.GetGdriveId(); err != nil || gid != xltest.GdriveId {
+		t.Errorf("unexpected gdriveid: %q, %v", gid, err)
+	}
+	srv.Expire()
+	if s.IsOn() {
+		t.Error("expected session to be expired")
+	}
+}
+
+func TestFakeServerTasks(t *testing.T) {
+	s, srv := newTestSession(t)
+	defer srv.Close()
+	done := srv.AddTask(xltest.Task{Name: "done.mkv", URL: "http://example.com/done.mkv", Size: 1 << 30, Type: xltest.TypeOrdinary, Status: xltest.StatusCompleted, Progress: 100})
+	srv.AddTask(xltest.Task{Name: "busy.mkv", URL: "http://example.com/busy.mkv", Size: 1 << 30, Type: xltest.TypeOrdinary, Status: xltest.StatusDownloading, Progress: 42})
+	if err := s.AddTask("http://example.com/new.iso"); err != nil {
+		t.Fatal(err)
+	}
+	ts, err := s.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 3 {
+		t.Fatalf("expected 3 tasks, got %d", len(ts))
+	}
+	if ts, err = s.GetCompletedTasks(); err != nil || len(ts) != 1 || ts[0].Id != done {
+		t.Errorf("unexpected completed tasks: %v, %v", ts, err)
+	}
+	if ts, err = s.GetIncompletedTasks(); err != nil || len(ts) != 2 {
+		t.Errorf("unexpected incompleted tasks: %v, %v", ts, err)
+	}
+	busy := findTask(ts, "busy.mkv")
+	if busy == nil {
+		t.Fatal("expected busy.mkv to be listed")
+	}
+	srv.UpdateTask(busy.Id, func(t *xltest.Task) { t.Progress = 99 })
+	if err = s.ProcessTask(nil); err != nil {
+		t.Fatal(err)
+	}
+	if busy.Progress != 99 {
+		t.Errorf("expected progress to be updated, got %v", busy.Progress)
+	}
+	if err = s.PauseTask(busy); err != nil {
+		t.Fatal(err)
+	}
+	if task, _ := srv.Task(busy.Id); task.Status != xltest.StatusPending {
+		t.Errorf("expected task to be paused, got status %d", task.Status)
+	}
+	if err = s.RenameTask(busy, "renamed.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	if task, _ := srv.Task(busy.Id); task.Name != "renamed.mkv" {
+		t.Errorf("expected task to be renamed, got %q", task.Name)
+	}
+	if err = s.DelayTask(busy); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteTask(busy); err != nil {
+		t.Fatal(err)
+	}
+	if ts, err = s.GetDeletedTasks(); err != nil || len(ts) != 1 || ts[0].Id != busy.Id {
+		t.Fatalf("unexpected deleted tasks: %v, %v", ts, err)
+	}
+	if ts[0].YsFileSize != "1073741824" || ts[0].Progress != 99 || ts[0].DtCommitted == "" {
+		t.Errorf("unexpected deleted task: %+v", ts[0])
+	}
+	if err = s.ResumeTask(ts[0]); err != nil {
+		t.Fatal(err)
+	}
+	if task, _ := srv.Task(busy.Id); task.Flag != xltest.FlagNormal || task.Status != xltest.StatusWaiting {
+		t.Errorf("expected task to be resumed, got flag %d status %d", task.Flag, task.Status)
+	}
+	srv.UpdateTask(done, func(t *xltest.Task) { t.Flag = xltest.FlagExpired })
+	if ts, err = s.GetExpiredTasks(); err != nil || len(ts) != 1 || ts[0].Id != done {
+		t.Errorf("unexpected expired tasks: %v, %v", ts, err)
+	}
+}
+
+func TestFakeServerBtTasks(t *testing.T) {
+	s, srv := newTestSession(t)
+	defer srv.Close()
+	magnet := srv.AddTorrent("magnet", xltest.File{Name: "a.mkv", Size: 1 << 20}, xltest.File{Name: "b.txt", Size: 1 << 10})
+	if err := s.AddTask("magnet:?xt=urn:btih:" + magnet); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTask("magnet:?xt=urn:btih:" + magnet); !errors.Is(err, ErrTaskExisted) {
+		t.Errorf("expected ErrTaskExisted, got %v", err)
+	}
+	hash := srv.AddTorrent("hash", xltest.File{Name: "c.mkv", Size: 1 << 20})
+	if err := s.AddTask(hash); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "xunlei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := srv.AddTorrent("file", xltest.File{Name: "dir/d.mkv", Size: 1 << 20}, xltest.File{Name: "dir/e.mkv", Size: 1 << 20})
+	torrent := filepath.Join(dir, "file.torrent")
+	if err = ioutil.WriteFile(torrent, srv.TorrentFile(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.AddTask(torrent); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.AddTask(torrent); !errors.Is(err, ErrTaskExisted) {
+		t.Errorf("expected ErrTaskExisted, got %v", err)
+	}
+	reported := srv.AddTorrent("reported", xltest.File{Name: "f.mkv", Size: 1 << 20})
+	srv.Report(reported)
+	if err = s.AddTask(reported); !errors.Is(err, ErrResourceReported) {
+		t.Errorf("expected ErrResourceReported, got %v", err)
+	}
+	ts, err := s.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := findTask(ts, "file")
+	if task == nil || !task.IsBt() {
+		t.Fatalf("expected bt task to be listed, got %v", ts)
+	}
+	list, err := s.FillBtList(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Files) != 2 || list.Files[1].Name != "dir/e.mkv" || list.Files[1].Dir != "dir" || list.Files[1].Size != 1<<20 {
+		t.Errorf("unexpected bt list: %v", list)
+	}
+	b, err := s.GetTorrentByHash(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(srv.TorrentFile(file)) {
+		t.Error("unexpected torrent file")
+	}
+}